@@ -0,0 +1,229 @@
+package acl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopub/log"
+	"github.com/gopub/wine"
+)
+
+// Rules of access control. Deny rules take precedence over allow rules.
+// If there are allow rules, only matched clients are allowed
+type Rules struct {
+	// Allow and Deny are CIDRs or IPs, e.g. 10.0.0.0/8, 203.0.113.7
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// AllowCountries and DenyCountries are ISO 3166-1 country codes, e.g. US. Filter.Geo is required
+	AllowCountries []string `json:"allow_countries,omitempty"`
+	DenyCountries  []string `json:"deny_countries,omitempty"`
+}
+
+// CountryLookup returns ISO 3166-1 country code of ip
+type CountryLookup interface {
+	Country(ip net.IP) (string, error)
+}
+
+type compiledRules struct {
+	rules          *Rules
+	allow          []*net.IPNet
+	deny           []*net.IPNet
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+}
+
+func compile(rules *Rules) (*compiledRules, error) {
+	c := &compiledRules{
+		rules:          rules,
+		allowCountries: toSet(rules.AllowCountries),
+		denyCountries:  toSet(rules.DenyCountries),
+	}
+	var err error
+	if c.allow, err = wine.ParseCIDRs(rules.Allow...); err != nil {
+		return nil, fmt.Errorf("parse allow: %w", err)
+	}
+	if c.deny, err = wine.ParseCIDRs(rules.Deny...); err != nil {
+		return nil, fmt.Errorf("parse deny: %w", err)
+	}
+	return c, nil
+}
+
+func toSet(l []string) map[string]bool {
+	m := make(map[string]bool, len(l))
+	for _, s := range l {
+		m[strings.ToUpper(strings.TrimSpace(s))] = true
+	}
+	return m
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter is an interceptor which rejects requests from disallowed client IPs with 403.
+// Client IP is resolved by server with trusted proxies
+type Filter struct {
+	// Geo resolves country of client IP for country rules. It should be set before serving
+	Geo CountryLookup
+
+	rules     atomic.Value
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ wine.Handler = (*Filter)(nil)
+
+func NewFilter(rules *Rules) (*Filter, error) {
+	f := &Filter{
+		stop: make(chan struct{}),
+	}
+	if err := f.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenFilter loads rules from a JSON file, and reloads rules once the file is modified.
+// Invalid rules are ignored and the previous rules are kept. Call Close to stop watching
+func OpenFilter(filename string, interval time.Duration) (*Filter, error) {
+	rules, modTime, err := readRules(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFilter(rules)
+	if err != nil {
+		return nil, err
+	}
+	go f.watch(filename, modTime, interval)
+	return f, nil
+}
+
+func readRules(filename string) (*Rules, time.Time, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("stat: %w", err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read file: %w", err)
+	}
+	rules := new(Rules)
+	if err = json.Unmarshal(b, rules); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unmarshal: %w", err)
+	}
+	return rules, fi.ModTime(), nil
+}
+
+func (f *Filter) watch(filename string, modTime time.Time, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			log.Errorf("Stat %s: %v", filename, err)
+			continue
+		}
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		rules, t, err := readRules(filename)
+		if err == nil {
+			err = f.SetRules(rules)
+		}
+		if err != nil {
+			log.Errorf("Reload %s: %v", filename, err)
+			continue
+		}
+		modTime = t
+		log.Infof("Reloaded %s", filename)
+	}
+}
+
+// SetRules replaces rules
+func (f *Filter) SetRules(rules *Rules) error {
+	if rules == nil {
+		rules = new(Rules)
+	}
+	c, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	f.rules.Store(c)
+	return nil
+}
+
+// Rules returns current rules
+func (f *Filter) Rules() *Rules {
+	return f.rules.Load().(*compiledRules).rules
+}
+
+// Allowed reports whether ip is allowed by rules
+func (f *Filter) Allowed(ip string) bool {
+	v := net.ParseIP(ip)
+	if v == nil {
+		return false
+	}
+	c := f.rules.Load().(*compiledRules)
+	if contains(c.deny, v) {
+		return false
+	}
+	var country string
+	if len(c.allowCountries) > 0 || len(c.denyCountries) > 0 {
+		country = f.lookup(v)
+		if c.denyCountries[country] {
+			return false
+		}
+	}
+	if len(c.allow) == 0 && len(c.allowCountries) == 0 {
+		return true
+	}
+	return contains(c.allow, v) || c.allowCountries[country]
+}
+
+func (f *Filter) lookup(ip net.IP) string {
+	if f.Geo == nil {
+		log.Warnf("Geo is nil, country rules are ignored")
+		return ""
+	}
+	country, err := f.Geo.Country(ip)
+	if err != nil {
+		log.Errorf("Lookup country of %v: %v", ip, err)
+		return ""
+	}
+	return strings.ToUpper(country)
+}
+
+func (f *Filter) HandleRequest(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	ip := wine.ClientIPKey(ctx, req)
+	if !f.Allowed(ip) {
+		log.FromContext(ctx).Warnf("Denied %s", ip)
+		return wine.Status(http.StatusForbidden)
+	}
+	return next(ctx, req)
+}
+
+// Close stops watching rules file
+func (f *Filter) Close() {
+	f.closeOnce.Do(func() {
+		close(f.stop)
+	})
+}
@@ -0,0 +1,106 @@
+package acl_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/acl"
+	"github.com/stretchr/testify/require"
+)
+
+type countries map[string]string
+
+func (c countries) Country(ip net.IP) (string, error) {
+	if v, ok := c[ip.String()]; ok {
+		return v, nil
+	}
+	return "", errors.New("not found")
+}
+
+func TestFilter(t *testing.T) {
+	f, err := acl.NewFilter(&acl.Rules{
+		Allow:          []string{"10.0.0.0/8", "203.0.113.7"},
+		Deny:           []string{"10.0.0.5"},
+		AllowCountries: []string{"nz"},
+		DenyCountries:  []string{"XX"},
+	})
+	require.NoError(t, err)
+	f.Geo = countries{"1.1.1.1": "NZ", "10.0.0.6": "XX", "2.2.2.2": "US"}
+
+	for ip, allowed := range map[string]bool{
+		"10.0.0.1":    true,
+		"203.0.113.7": true,
+		"10.0.0.5":    false,
+		"10.0.0.6":    false,
+		"1.1.1.1":     true,
+		"2.2.2.2":     false,
+		"3.3.3.3":     false,
+		"invalid":     false,
+	} {
+		require.Equal(t, allowed, f.Allowed(ip), ip)
+	}
+
+	_, err = acl.NewFilter(&acl.Rules{Allow: []string{"10.0.0.0/40"}})
+	require.Error(t, err)
+}
+
+func TestFilterHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "rules.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"allow": ["10.0.0.0/8"]}`), 0644))
+	f, err := acl.OpenFilter(filename, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer f.Close()
+
+	s := wine.NewServer()
+	s.TrustedProxies, err = wine.ParseCIDRs("127.0.0.1")
+	require.NoError(t, err)
+	ok := func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Status(http.StatusOK)
+	}
+	s.Get("public", ok)
+	s.Group("admin").UseHandlers(f).Get("users", ok)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	get := func(path, ip string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set(wine.HeaderXForwardedFor, ip)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, get("/public", "1.2.3.4"))
+	require.Equal(t, http.StatusForbidden, get("/admin/users", "1.2.3.4"))
+	require.Equal(t, http.StatusOK, get("/admin/users", "10.1.2.3"))
+
+	// Make sure modification time changes
+	mtime := time.Now().Add(time.Second)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"allow": ["1.2.3.4"]}`), 0644))
+	require.NoError(t, os.Chtimes(filename, mtime, mtime))
+	require.Eventually(t, func() bool {
+		return len(f.Rules().Allow) == 1 && f.Rules().Allow[0] == "1.2.3.4"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusOK, get("/admin/users", "1.2.3.4"))
+	require.Equal(t, http.StatusForbidden, get("/admin/users", "10.1.2.3"))
+
+	// Invalid rules are ignored
+	mtime = mtime.Add(time.Second)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"allow": ["invalid"]}`), 0644))
+	require.NoError(t, os.Chtimes(filename, mtime, mtime))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, http.StatusOK, get("/admin/users", "1.2.3.4"))
+}
@@ -0,0 +1,39 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MaxMind looks up countries in a MaxMind-format database, e.g. GeoLite2-Country.mmdb
+type MaxMind struct {
+	r *maxminddb.Reader
+}
+
+var _ CountryLookup = (*MaxMind)(nil)
+
+func OpenMaxMind(filename string) (*MaxMind, error) {
+	r, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", filename, err)
+	}
+	return &MaxMind{r: r}, nil
+}
+
+func (m *MaxMind) Country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := m.r.Lookup(ip, &record); err != nil {
+		return "", fmt.Errorf("lookup: %w", err)
+	}
+	return record.Country.ISOCode, nil
+}
+
+func (m *MaxMind) Close() error {
+	return m.r.Close()
+}
@@ -9,10 +9,13 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gopub/types"
 
 	"github.com/gopub/log"
+	"github.com/gopub/wine"
 	"github.com/gopub/wine/mime"
 )
 
@@ -30,6 +33,10 @@ type Client struct {
 	HeaderBuilder  HeaderBuilder
 	RequestLogging bool
 	UseResultModel bool
+
+	// PropagateDeadline sends remaining time of ctx's deadline in header X-Request-Timeout.
+	// Enable it only for calls to wine servers, e.g. internal services
+	PropagateDeadline bool
 }
 
 var DefaultClient = NewClient(http.DefaultClient)
@@ -61,6 +68,17 @@ func (c *Client) injectHeader(req *http.Request) {
 	if c.HeaderBuilder != nil {
 		req.Header = c.HeaderBuilder.Build(req.Context(), req.Header)
 	}
+	if !c.PropagateDeadline {
+		return
+	}
+	// Propagate remaining time of deadline, e.g. deadline of the request being handled by server
+	if d, ok := req.Context().Deadline(); ok && req.Header.Get(wine.HeaderRequestTimeout) == "" {
+		ms := time.Until(d).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		req.Header.Set(wine.HeaderRequestTimeout, strconv.FormatInt(ms, 10))
+	}
 }
 
 // Get executes http get request created with endpoint and query
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gopub/types"
+	"github.com/gopub/wine/mime"
+	"github.com/gopub/wine/sse"
+)
+
+// EventStream reads server-sent events
+type EventStream struct {
+	*sse.Reader
+	body io.ReadCloser
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+// OpenEventStream connects to an event stream. Events after lastEventID are replayed if it's not empty
+func (c *Client) OpenEventStream(ctx context.Context, endpoint string, lastEventID string) (*EventStream, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %s, %w", endpoint, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", mime.EventStream)
+	if lastEventID != "" {
+		req.Header.Set(sse.HeaderLastEventID, lastEventID)
+	}
+	c.injectHeader(req)
+	if c.RequestLogging {
+		c.dumpRequest(req)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", types.NewError(StatusTransportFailed, err.Error()))
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err = ParseResult(resp, nil, !c.UseResultModel); err != nil {
+			return nil, fmt.Errorf("parse result: %w", err)
+		}
+		return nil, types.NewError(resp.StatusCode, "unexpected status")
+	}
+	if ct := mime.GetContentType(resp.Header); ct != mime.EventStream {
+		resp.Body.Close()
+		return nil, types.NewError(StatusInvalidResponse, fmt.Sprintf("unexpected content type %s", ct))
+	}
+	r := sse.NewReader(resp.Body)
+	r.LastEventID = lastEventID
+	return &EventStream{
+		Reader: r,
+		body:   resp.Body,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSOptions configures client certificate and server certificate verification
+type TLSOptions struct {
+	// CertFile and KeyFile are PEM encoded client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// RootCAFiles verify server certificates. System pool is used if it's empty
+	RootCAFiles []string
+	// ServerName overrides host name to verify server certificates
+	ServerName string
+	// Pins are base64 encoded SHA-256 hashes of SubjectPublicKeyInfo, which can be calculated by PinSHA256.
+	// If it's not empty, at least one certificate in server's verified chains, e.g. leaf or CA, must match
+	Pins []string
+}
+
+// PinSHA256 returns base64 encoded SHA-256 hash of certificate's SubjectPublicKeyInfo
+func PinSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// NewTLSConfig returns tls config of options
+func NewTLSConfig(options *TLSOptions) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName: options.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if options.CertFile != "" || options.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load key pair: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if len(options.RootCAFiles) > 0 {
+		pool := x509.NewCertPool()
+		for _, f := range options.RootCAFiles {
+			b, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("read file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no certificates in %s", f)
+			}
+		}
+		c.RootCAs = pool
+	}
+	if len(options.Pins) > 0 {
+		pins := make(map[string]bool, len(options.Pins))
+		for _, p := range options.Pins {
+			pins[p] = true
+		}
+		c.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					if pins[PinSHA256(cert)] {
+						return nil
+					}
+				}
+			}
+			return errors.New("no pinned certificate")
+		}
+	}
+	return c, nil
+}
+
+// NewTLSClient returns a client with client certificate and server certificate verification of options
+func NewTLSClient(options *TLSOptions) (*Client, error) {
+	c, err := NewTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = c
+	return NewClient(&http.Client{Transport: t}), nil
+}
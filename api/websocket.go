@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gopub/types"
+	"github.com/gopub/wine/websocket"
+)
+
+// DialWebSocket connects to a websocket endpoint with shared header and TLS config of c
+func (c *Client) DialWebSocket(ctx context.Context, endpoint string, options *websocket.DialOptions) (*websocket.Conn, error) {
+	var opts websocket.DialOptions
+	if options != nil {
+		opts = *options
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %s, %w", endpoint, err)
+	}
+	req = req.WithContext(ctx)
+	for k, vs := range opts.Header {
+		req.Header[k] = vs
+	}
+	c.injectHeader(req)
+	opts.Header = req.Header
+	if opts.TLSConfig == nil {
+		if t, ok := c.client.Transport.(*http.Transport); ok {
+			opts.TLSConfig = t.TLSClientConfig
+		}
+	}
+	conn, resp, err := websocket.Dial(ctx, endpoint, &opts)
+	if err != nil {
+		if resp != nil {
+			err = types.NewError(resp.StatusCode, err.Error())
+		} else {
+			err = types.NewError(StatusTransportFailed, err.Error())
+		}
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,279 @@
+package wine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// CertFile is a pair of PEM encoded certificate and key files
+type CertFile struct {
+	Cert string
+	Key  string
+}
+
+// AutoTLSOptions configures certificates of RunAutoTLS
+type AutoTLSOptions struct {
+	// Domains are host names whose certificates are obtained from ACME CA
+	Domains []string
+	// Email is the contact of ACME account, which is optional
+	Email string
+	// CacheDir stores ACME account key and certificates, default is "certs"
+	CacheDir string
+	// DirectoryURL is the directory of ACME CA, default is Let's Encrypt
+	DirectoryURL string
+	// HTTPClient is used to communicate with ACME CA
+	HTTPClient *http.Client
+
+	// Certs are served by SNI, and reloaded once files are modified
+	Certs []CertFile
+	// ReloadInterval is the interval of checking modification of Certs, default is 1 minute
+	ReloadInterval time.Duration
+
+	// RedirectAddr is the address of HTTP listener, e.g. ":80", which redirects to HTTPS and serves ACME http-01 challenges.
+	// Empty value disables it
+	RedirectAddr string
+}
+
+func (o *AutoTLSOptions) withDefaults() AutoTLSOptions {
+	var opts AutoTLSOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.CacheDir == "" {
+		opts.CacheDir = "certs"
+	}
+	if opts.DirectoryURL == "" {
+		opts.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = time.Minute
+	}
+	return opts
+}
+
+type loadedCert struct {
+	file    CertFile
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// CertManager provides certificates from files and ACME CA
+type CertManager struct {
+	options AutoTLSOptions
+	acme    *autocert.Manager
+
+	mu        sync.RWMutex
+	certs     []*loadedCert
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCertManager loads certificate files, and starts watching them. Call Close to stop watching
+func NewCertManager(options *AutoTLSOptions) (*CertManager, error) {
+	m := &CertManager{
+		options: options.withDefaults(),
+		stop:    make(chan struct{}),
+	}
+	if len(m.options.Domains) == 0 && len(m.options.Certs) == 0 {
+		return nil, errors.New("no domains or certs")
+	}
+	for _, f := range m.options.Certs {
+		c, err := loadCert(f)
+		if err != nil {
+			return nil, err
+		}
+		m.certs = append(m.certs, c)
+	}
+	if len(m.options.Domains) > 0 {
+		m.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(m.options.CacheDir),
+			HostPolicy: autocert.HostWhitelist(m.options.Domains...),
+			Email:      m.options.Email,
+			Client: &acme.Client{
+				DirectoryURL: m.options.DirectoryURL,
+				HTTPClient:   m.options.HTTPClient,
+			},
+		}
+	}
+	if len(m.certs) > 0 {
+		go m.watch()
+	}
+	return m, nil
+}
+
+func loadCert(f CertFile) (*loadedCert, error) {
+	fi, err := os.Stat(f.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", f.Cert, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", f.Cert, err)
+		}
+	}
+	return &loadedCert{file: f, cert: &cert, modTime: fi.ModTime()}, nil
+}
+
+func (m *CertManager) watch() {
+	ticker := time.NewTicker(m.options.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		m.mu.RLock()
+		certs := m.certs
+		m.mu.RUnlock()
+		for i, c := range certs {
+			// Key file is usually replaced before certificate file
+			fi, err := os.Stat(c.file.Cert)
+			if err != nil {
+				logger.Errorf("Stat %s: %v", c.file.Cert, err)
+				continue
+			}
+			if fi.ModTime().Equal(c.modTime) {
+				continue
+			}
+			nc, err := loadCert(c.file)
+			if err != nil {
+				logger.Errorf("Reload %s: %v", c.file.Cert, err)
+				continue
+			}
+			m.mu.Lock()
+			m.certs[i] = nc
+			m.mu.Unlock()
+			logger.Infof("Reloaded %s", c.file.Cert)
+		}
+	}
+}
+
+// GetCertificate chooses certificate by SNI. Certificate files take precedence over ACME.
+// The first certificate file is used if no certificate matches
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if m.acme != nil {
+		for _, p := range hello.SupportedProtos {
+			if p == acme.ALPNProto {
+				return m.acme.GetCertificate(hello)
+			}
+		}
+	}
+	m.mu.RLock()
+	certs := m.certs
+	m.mu.RUnlock()
+	if name != "" {
+		for _, c := range certs {
+			if c.cert.Leaf.VerifyHostname(name) == nil {
+				return c.cert, nil
+			}
+		}
+		if m.acme != nil {
+			for _, d := range m.options.Domains {
+				if strings.EqualFold(d, name) {
+					return m.acme.GetCertificate(hello)
+				}
+			}
+		}
+	}
+	if len(certs) > 0 {
+		return certs[0].cert, nil
+	}
+	return nil, fmt.Errorf("no certificate for %q", name)
+}
+
+// TLSConfig returns tls config which serves certificates of m
+func (m *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// HTTPHandler serves ACME http-01 challenges, and other requests are handled by fallback.
+// If fallback is nil, requests are redirected to HTTPS
+func (m *CertManager) HTTPHandler(fallback http.Handler) http.Handler {
+	if fallback == nil {
+		fallback = redirectHTTPS("")
+	}
+	if m.acme == nil {
+		return fallback
+	}
+	return m.acme.HTTPHandler(fallback)
+}
+
+// Close stops watching certificate files
+func (m *CertManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// redirectHTTPS redirects requests to https with port, which is omitted if it's empty or 443
+func redirectHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// RunAutoTLS starts server with certificates from files and ACME CA
+func (s *Server) RunAutoTLS(addr string, options *AutoTLSOptions) {
+	m, err := NewCertManager(options)
+	if err != nil {
+		logger.Fatalf("NewCertManager: %v", err)
+	}
+	defer m.Close()
+
+	if redirectAddr := m.options.RedirectAddr; redirectAddr != "" {
+		_, port, _ := net.SplitHostPort(addr)
+		redirectServer := &http.Server{Addr: redirectAddr, Handler: m.HTTPHandler(redirectHTTPS(port))}
+		s.addAuxServer(redirectServer)
+		go func() {
+			logger.Infof("Redirecting at %s ...", redirectAddr)
+			err := redirectServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Fatalf("ListenAndServe: %v", err)
+			}
+		}()
+	}
+
+	l := listenTLS(addr)
+	logger.Infof("Running at %s ...", l.Addr())
+	err = s.start(addr, s.TLSConfig(m.TLSConfig()), l).ServeTLS(l, "", "")
+	if err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			logger.Infof("Server closed")
+		} else {
+			logger.Fatalf("ListenAndServe: %v", err)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package wine_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{key: key, cert: cert}
+}
+
+func (ca *testCA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Issue returns DER of a certificate for names
+func (ca *testCA) Issue(t *testing.T, pub interface{}, serial int64, names ...string) []byte {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	require.NoError(t, err)
+	return der
+}
+
+// WriteFiles writes certificate and key of names into dir
+func (ca *testCA) WriteFiles(t *testing.T, dir string, serial int64, names ...string) wine.CertFile {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	f := wine.CertFile{
+		Cert: filepath.Join(dir, names[0]+".crt"),
+		Key:  filepath.Join(dir, names[0]+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Issue(t, &key.PublicKey, serial, names...)})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, ioutil.WriteFile(f.Key, keyPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(f.Cert, certPEM, 0644))
+	return f
+}
+
+// fakeACME is a minimal ACME (RFC 8555) CA, which validates http-01 challenges by fetching them from challengeURL
+type fakeACME struct {
+	t            *testing.T
+	ca           *testCA
+	server       *httptest.Server
+	challengeURL string
+
+	mu     sync.Mutex
+	orders map[string]*fakeOrder
+}
+
+type fakeOrder struct {
+	Domain string
+	Token  string
+	Status string
+	Valid  bool
+	Cert   []byte
+}
+
+func newFakeACME(t *testing.T, ca *testCA) *fakeACME {
+	a := &fakeACME{t: t, ca: ca, orders: map[string]*fakeOrder{}}
+	a.server = httptest.NewServer(http.HandlerFunc(a.serve))
+	return a
+}
+
+func (a *fakeACME) serve(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprint(time.Now().UnixNano()))
+	w.Header().Set("Content-Type", "application/json")
+	base := a.server.URL
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	var payload []byte
+	if req.Method == http.MethodPost {
+		var jws struct {
+			Payload string `json:"payload"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&jws); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload, _ = base64.RawURLEncoding.DecodeString(jws.Payload)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var o *fakeOrder
+	if len(parts) == 2 {
+		if o = a.orders[parts[1]]; o == nil {
+			http.NotFound(w, req)
+			return
+		}
+	}
+	orderJSON := func(id string) interface{} {
+		v := map[string]interface{}{
+			"status":         o.Status,
+			"identifiers":    []interface{}{map[string]string{"type": "dns", "value": o.Domain}},
+			"authorizations": []string{base + "/authz/" + id},
+			"finalize":       base + "/finalize/" + id,
+		}
+		if o.Cert != nil {
+			v["certificate"] = base + "/cert/" + id
+		}
+		return v
+	}
+	challengeJSON := func(id string) map[string]interface{} {
+		status := "pending"
+		if o.Valid {
+			status = "valid"
+		}
+		return map[string]interface{}{
+			"type":   "http-01",
+			"url":    base + "/challenge/" + id,
+			"token":  o.Token,
+			"status": status,
+		}
+	}
+
+	switch parts[0] {
+	case "directory":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   base + "/nonce",
+			"newAccount": base + "/account",
+			"newOrder":   base + "/order",
+			"revokeCert": base + "/revoke",
+			"keyChange":  base + "/key",
+		})
+	case "nonce":
+		w.WriteHeader(http.StatusOK)
+	case "account":
+		w.Header().Set("Location", base+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+	case "order":
+		if len(parts) == 2 {
+			json.NewEncoder(w).Encode(orderJSON(parts[1]))
+			return
+		}
+		var v struct {
+			Identifiers []struct {
+				Value string `json:"value"`
+			} `json:"identifiers"`
+		}
+		require.NoError(a.t, json.Unmarshal(payload, &v))
+		id := fmt.Sprint(len(a.orders) + 1)
+		o = &fakeOrder{Domain: v.Identifiers[0].Value, Token: "token" + id, Status: "pending"}
+		a.orders[id] = o
+		w.Header().Set("Location", base+"/order/"+id)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(orderJSON(id))
+	case "authz":
+		status := "pending"
+		if o.Valid {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": o.Domain},
+			"challenges": []interface{}{challengeJSON(parts[1])},
+		})
+	case "challenge":
+		if !o.Valid {
+			o.Valid = a.validate(o)
+			if o.Valid {
+				o.Status = "ready"
+			}
+		}
+		json.NewEncoder(w).Encode(challengeJSON(parts[1]))
+	case "finalize":
+		var v struct {
+			CSR string `json:"csr"`
+		}
+		require.NoError(a.t, json.Unmarshal(payload, &v))
+		der, err := base64.RawURLEncoding.DecodeString(v.CSR)
+		require.NoError(a.t, err)
+		csr, err := x509.ParseCertificateRequest(der)
+		require.NoError(a.t, err)
+		o.Cert = a.ca.Issue(a.t, csr.PublicKey, time.Now().UnixNano(), csr.DNSNames...)
+		o.Status = "valid"
+		w.Header().Set("Location", base+"/order/"+parts[1])
+		json.NewEncoder(w).Encode(orderJSON(parts[1]))
+	case "cert":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: o.Cert})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: a.ca.cert.Raw})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (a *fakeACME) validate(o *fakeOrder) bool {
+	req, err := http.NewRequest(http.MethodGet, a.challengeURL+"/.well-known/acme-challenge/"+o.Token, nil)
+	require.NoError(a.t, err)
+	req.Host = o.Domain
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return err == nil && resp.StatusCode == http.StatusOK && strings.HasPrefix(string(b), o.Token+".")
+}
+
+func TestAutoTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autotls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	acme := newFakeACME(t, ca)
+	defer acme.server.Close()
+	files := ca.WriteFiles(t, dir, 1, "files.test", "*.files.test")
+
+	m, err := wine.NewCertManager(&wine.AutoTLSOptions{
+		Domains:        []string{"acme.test"},
+		CacheDir:       filepath.Join(dir, "cache"),
+		DirectoryURL:   acme.server.URL + "/directory",
+		Certs:          []wine.CertFile{files},
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	redirect := httptest.NewServer(m.HTTPHandler(nil))
+	defer redirect.Close()
+	acme.challengeURL = redirect.URL
+
+	s := wine.NewServer()
+	s.Get("hello", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, "hello")
+	})
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = m.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	// get returns serial number of server certificate
+	get := func(serverName string) *big.Int {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{ServerName: serverName, RootCAs: ca.Pool()},
+			},
+		}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get(ts.URL + "/hello")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b))
+		return resp.TLS.PeerCertificates[0].SerialNumber
+	}
+
+	t.Run("ACME", func(t *testing.T) {
+		get("acme.test")
+		// Certificate is cached
+		matches, err := filepath.Glob(filepath.Join(dir, "cache", "acme.test*"))
+		require.NoError(t, err)
+		require.NotEmpty(t, matches)
+	})
+
+	t.Run("SNI", func(t *testing.T) {
+		require.Equal(t, int64(1), get("files.test").Int64())
+		require.Equal(t, int64(1), get("www.files.test").Int64())
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		ca.WriteFiles(t, dir, 2, "files.test", "*.files.test")
+		mtime := time.Now().Add(time.Second)
+		require.NoError(t, os.Chtimes(files.Cert, mtime, mtime))
+		require.Eventually(t, func() bool {
+			return get("files.test").Int64() == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		client := &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		req, err := http.NewRequest(http.MethodGet, redirect.URL+"/hello?a=1", nil)
+		require.NoError(t, err)
+		req.Host = "acme.test:8080"
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		require.Equal(t, "https://acme.test/hello?a=1", resp.Header.Get("Location"))
+	})
+}
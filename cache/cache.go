@@ -0,0 +1,301 @@
+// Package cache provides a handler which caches complete responses of GET and HEAD requests
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopub/log"
+	"github.com/gopub/types"
+	"github.com/gopub/wine"
+)
+
+// Header keys
+const (
+	HeaderXCache = "X-Cache"
+	HeaderAge    = "Age"
+)
+
+// Values of X-Cache
+const (
+	Hit   = "HIT"
+	Miss  = "MISS"
+	Stale = "STALE"
+)
+
+var _ wine.Handler = (*Handler)(nil)
+
+// Handler caches responses with status 200, which are not marked as no-store or private by Cache-Control
+// and don't set cookies.
+// Concurrent requests with the same key are coalesced, so that a cache miss triggers only one computation.
+type Handler struct {
+	store Store
+	ttl   time.Duration
+	calls *callGroup
+
+	// epoch is increased by Invalidate, entries computed in an earlier epoch are not saved
+	mu    sync.RWMutex
+	epoch uint64
+
+	// StaleWhileRevalidate is the duration after ttl, during which stale response is served while it's being
+	// recomputed in background.
+	// Background computation runs with wine.DetachContext, which has no response writer and HTTP request,
+	// e.g. wine.GetResponseWriter returns nil and wine.StreamFile writes the whole content regardless of
+	// range and conditional headers
+	StaleWhileRevalidate time.Duration
+	// Params are names of request parameters which are included in cache key
+	Params []string
+	// Vary are names of request headers which are included in cache key
+	Vary []string
+	// Tags returns tags of response for req, which can be used to invalidate entries
+	Tags func(req *wine.Request) []string
+}
+
+func NewHandler(store Store, ttl time.Duration) *Handler {
+	if ttl <= 0 {
+		log.Panic("ttl must be positive")
+	}
+	return &Handler{
+		store: store,
+		ttl:   ttl,
+		calls: newCallGroup(),
+	}
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	method := req.Request().Method
+	if method != http.MethodGet && method != http.MethodHead {
+		return next(ctx, req)
+	}
+
+	key := h.Key(req)
+	now := time.Now()
+	e, err := h.store.Get(ctx, key)
+	if err != nil && !errors.Is(err, types.ErrNotExist) {
+		log.FromContext(ctx).Errorf("Get %s: %v", key, err)
+	}
+	if e != nil {
+		if e.IsFresh(now) {
+			return newResponder(e, Hit)
+		}
+		if e.IsUsable(now) {
+			// Response writer and HTTP request are not available after the request finishes
+			bgCtx := wine.DetachContext(ctx)
+			go h.calls.Do(key, func() *Entry {
+				return h.compute(bgCtx, req, next, key)
+			})
+			return newResponder(e, Stale)
+		}
+	}
+
+	e, shared := h.calls.Do(key, func() *Entry {
+		return h.compute(ctx, req, next, key)
+	})
+	if e == nil {
+		// The computation panicked
+		return wine.Status(http.StatusInternalServerError)
+	}
+	if shared && !isCacheable(e) {
+		// Response computed for another request may be private to its client
+		e = h.compute(ctx, req, next, key)
+	}
+	return newResponder(e, Miss)
+}
+
+// Key returns cache key of req
+func (h *Handler) Key(req *wine.Request) string {
+	b := new(strings.Builder)
+	b.WriteString(req.Request().Method)
+	b.WriteString(" /")
+	b.WriteString(req.NormalizedPath())
+	if len(h.Params) > 0 {
+		params := make([]string, len(h.Params))
+		copy(params, h.Params)
+		sort.Strings(params)
+		for i, name := range params {
+			if i == 0 {
+				b.WriteByte('?')
+			} else {
+				b.WriteByte('&')
+			}
+			b.WriteString(name)
+			b.WriteByte('=')
+			b.WriteString(fmt.Sprint(req.Params()[name]))
+		}
+	}
+	for _, name := range h.Vary {
+		b.WriteString("\n")
+		b.WriteString(http.CanonicalHeaderKey(name))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(req.Request().Header[http.CanonicalHeaderKey(name)], ","))
+	}
+	return b.String()
+}
+
+// Invalidate removes entries which have any of tags
+func (h *Handler) Invalidate(ctx context.Context, tags ...string) error {
+	// In-flight computations may have read the data before it's changed
+	h.mu.Lock()
+	h.epoch++
+	h.mu.Unlock()
+	return h.store.DeleteTags(ctx, tags...)
+}
+
+// InvalidateOnSuccess returns a handler which invalidates entries with tags if the request is processed successfully
+// e.g. r.Post("items", h.InvalidateOnSuccess("items"), createItem)
+func (h *Handler) InvalidateOnSuccess(tags ...string) wine.HandlerFunc {
+	return func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		resp := next(ctx, req)
+		if r, ok := resp.(*wine.Response); ok && r.Status() >= http.StatusBadRequest {
+			return resp
+		}
+		if err := h.Invalidate(ctx, tags...); err != nil {
+			log.FromContext(ctx).Errorf("Invalidate %v: %v", tags, err)
+		}
+		return resp
+	}
+}
+
+// compute invokes next handler and records the response.
+// The entry is saved if it's cacheable and not invalidated during computation
+func (h *Handler) compute(ctx context.Context, req *wine.Request, next wine.Invoker, key string) *Entry {
+	h.mu.RLock()
+	epoch := h.epoch
+	h.mu.RUnlock()
+	resp := next(ctx, req)
+	if resp == nil {
+		resp = wine.Status(http.StatusNotImplemented)
+	}
+	rec := newRecorder()
+	resp.Respond(ctx, rec)
+	now := time.Now()
+	e := &Entry{
+		Status:     rec.status,
+		Header:     rec.header,
+		Body:       rec.body.Bytes(),
+		CreatedAt:  now,
+		FreshUntil: now.Add(h.ttl),
+		StaleUntil: now.Add(h.ttl + h.StaleWhileRevalidate),
+	}
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	if !isCacheable(e) {
+		return e
+	}
+	if h.Tags != nil {
+		e.Tags = h.Tags(req)
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.epoch != epoch {
+		return e
+	}
+	if err := h.store.Set(ctx, key, e); err != nil {
+		log.FromContext(ctx).Errorf("Set %s: %v", key, err)
+	}
+	return e
+}
+
+func isCacheable(e *Entry) bool {
+	if e.Status != http.StatusOK {
+		return false
+	}
+	if len(e.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	cc := strings.ToLower(e.Header.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+func newResponder(e *Entry, status string) wine.Responder {
+	return wine.ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
+		for k, v := range e.Header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(HeaderXCache, status)
+		if status != Miss {
+			w.Header().Set(HeaderAge, fmt.Sprint(int(time.Since(e.CreatedAt).Seconds())))
+		}
+		w.WriteHeader(e.Status)
+		if _, err := w.Write(e.Body); err != nil {
+			log.FromContext(ctx).Errorf("Write: %v", err)
+		}
+	})
+}
+
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		header: make(http.Header),
+	}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *recorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(data)
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	entry *Entry
+}
+
+// callGroup ensures only one computation is in-flight for a key
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func newCallGroup() *callGroup {
+	return &callGroup{
+		calls: make(map[string]*call),
+	}
+}
+
+// Do returns entry computed by fn, shared is true if the entry was computed for another caller
+func (g *callGroup) Do(key string, fn func() *Entry) (e *Entry, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.entry, true
+	}
+	c := new(call)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.entry = fn()
+	return c.entry, false
+}
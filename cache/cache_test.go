@@ -0,0 +1,206 @@
+package cache_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	var counter int32
+	h := cache.NewHandler(cache.NewLRUStore(10), time.Minute)
+	h.Params = []string{"page"}
+	h.Tags = func(req *wine.Request) []string {
+		return []string{"items"}
+	}
+	s := wine.NewServer()
+	r := s.Group("items")
+	r.UseHandlers(h).Get("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		n := atomic.AddInt32(&counter, 1)
+		return wine.Text(http.StatusOK, fmt.Sprint(n))
+	})
+	r.Use(h.InvalidateOnSuccess("items")).Post("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Status(http.StatusCreated)
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, body := get(t, ts.URL+"/items?page=1")
+	assert.Equal(t, cache.Miss, resp.Header.Get(cache.HeaderXCache))
+	assert.Equal(t, "1", body)
+
+	resp, body = get(t, ts.URL+"/items?page=1&other=1")
+	assert.Equal(t, cache.Hit, resp.Header.Get(cache.HeaderXCache))
+	assert.Equal(t, "1", body)
+
+	_, body = get(t, ts.URL+"/items?page=2")
+	assert.Equal(t, "2", body)
+
+	resp, err := http.Post(ts.URL+"/items", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, body = get(t, ts.URL+"/items?page=1")
+	assert.Equal(t, cache.Miss, resp.Header.Get(cache.HeaderXCache))
+	assert.Equal(t, "3", body)
+}
+
+func TestCoalescing(t *testing.T) {
+	var counter int32
+	h := cache.NewHandler(cache.NewLRUStore(10), time.Minute)
+	s := wine.NewServer()
+	s.UseHandlers(h).Get("/slow", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		atomic.AddInt32(&counter, 1)
+		time.Sleep(100 * time.Millisecond)
+		return wine.Text(http.StatusOK, "done")
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, body := get(t, ts.URL+"/slow")
+			assert.Equal(t, "done", body)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&counter))
+}
+
+func TestSetCookie(t *testing.T) {
+	var counter int32
+	h := cache.NewHandler(cache.NewLRUStore(10), time.Minute)
+	s := wine.NewServer()
+	s.UseHandlers(h).Get("/login", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		n := atomic.AddInt32(&counter, 1)
+		time.Sleep(50 * time.Millisecond)
+		resp := wine.Text(http.StatusOK, "welcome").(*wine.Response)
+		resp.Header().Add("Set-Cookie", fmt.Sprintf("sid=%d", n))
+		return resp
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var mu sync.Mutex
+	cookies := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := get(t, ts.URL+"/login")
+			assert.Equal(t, cache.Miss, resp.Header.Get(cache.HeaderXCache))
+			mu.Lock()
+			cookies[resp.Header.Get("Set-Cookie")] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	require.Len(t, cookies, 3)
+
+	resp, _ := get(t, ts.URL+"/login")
+	require.Equal(t, cache.Miss, resp.Header.Get(cache.HeaderXCache))
+	require.Equal(t, "sid=4", resp.Header.Get("Set-Cookie"))
+}
+
+func TestInvalidateDuringCompute(t *testing.T) {
+	var counter int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := cache.NewHandler(cache.NewLRUStore(10), time.Minute)
+	s := wine.NewServer()
+	s.UseHandlers(h).Get("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		n := atomic.AddInt32(&counter, 1)
+		if n == 1 {
+			close(started)
+			<-release
+		}
+		return wine.Text(http.StatusOK, fmt.Sprint(n))
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	done := make(chan string)
+	go func() {
+		_, body := get(t, ts.URL)
+		done <- body
+	}()
+	<-started
+	require.NoError(t, h.Invalidate(context.Background(), "items"))
+	close(release)
+	require.Equal(t, "1", <-done)
+
+	// Response computed before invalidation isn't saved
+	resp, body := get(t, ts.URL)
+	require.Equal(t, cache.Miss, resp.Header.Get(cache.HeaderXCache))
+	require.Equal(t, "2", body)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var counter int32
+	h := cache.NewHandler(cache.NewLRUStore(10), 100*time.Millisecond)
+	h.StaleWhileRevalidate = time.Minute
+	s := wine.NewServer()
+	s.UseHandlers(h).Get("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		n := atomic.AddInt32(&counter, 1)
+		return wine.Text(http.StatusOK, fmt.Sprint(n))
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	_, body := get(t, ts.URL)
+	assert.Equal(t, "1", body)
+	time.Sleep(150 * time.Millisecond)
+
+	resp, body := get(t, ts.URL)
+	assert.Equal(t, cache.Stale, resp.Header.Get(cache.HeaderXCache))
+	assert.Equal(t, "1", body)
+
+	time.Sleep(20 * time.Millisecond)
+	resp, body = get(t, ts.URL)
+	assert.Equal(t, cache.Hit, resp.Header.Get(cache.HeaderXCache))
+	assert.Equal(t, "2", body)
+}
+
+func TestLRUStore(t *testing.T) {
+	ctx := context.Background()
+	s := cache.NewLRUStore(2)
+	newEntry := func(tags ...string) *cache.Entry {
+		return &cache.Entry{Status: http.StatusOK, Tags: tags, StaleUntil: time.Now().Add(time.Minute)}
+	}
+	require.NoError(t, s.Set(ctx, "a", newEntry("x")))
+	require.NoError(t, s.Set(ctx, "b", newEntry("y")))
+	_, err := s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, s.Set(ctx, "c", newEntry("x")))
+	_, err = s.Get(ctx, "b")
+	require.Error(t, err)
+	require.Equal(t, 2, s.Len())
+
+	require.NoError(t, s.DeleteTags(ctx, "x"))
+	require.Equal(t, 0, s.Len())
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gopub/log"
+	"github.com/gopub/types"
+)
+
+// Entry is a complete cached response
+type Entry struct {
+	Status     int
+	Header     http.Header
+	Body       []byte
+	Tags       []string
+	CreatedAt  time.Time
+	FreshUntil time.Time
+	// StaleUntil is the time before which the stale entry can still be served while being revalidated
+	StaleUntil time.Time
+}
+
+// IsFresh returns true if e can be served without revalidation
+func (e *Entry) IsFresh(now time.Time) bool {
+	return now.Before(e.FreshUntil)
+}
+
+// IsUsable returns true if e is fresh or can be served while being revalidated
+func (e *Entry) IsUsable(now time.Time) bool {
+	return now.Before(e.StaleUntil)
+}
+
+// Store saves cache entries. Get should return types.ErrNotExist if key doesn't exist
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, e *Entry) error
+	Delete(ctx context.Context, key string) error
+	// DeleteTags removes all entries which have any of tags
+	DeleteTags(ctx context.Context, tags ...string) error
+}
+
+var _ Store = (*LRUStore)(nil)
+
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// LRUStore is an in-memory store which evicts the least recently used entry if capacity is reached
+type LRUStore struct {
+	mu       sync.Mutex
+	capacity int
+	items    *list.List
+	keyToElm map[string]*list.Element
+	tagToKey map[string]map[string]bool
+}
+
+func NewLRUStore(capacity int) *LRUStore {
+	if capacity <= 0 {
+		log.Panic("capacity must be positive")
+	}
+	return &LRUStore{
+		capacity: capacity,
+		items:    list.New(),
+		keyToElm: make(map[string]*list.Element, capacity),
+		tagToKey: make(map[string]map[string]bool),
+	}
+}
+
+func (s *LRUStore) Get(ctx context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elm := s.keyToElm[key]
+	if elm == nil {
+		return nil, types.ErrNotExist
+	}
+	e := elm.Value.(*lruItem).entry
+	if !e.IsUsable(time.Now()) {
+		s.remove(elm)
+		return nil, types.ErrNotExist
+	}
+	s.items.MoveToFront(elm)
+	return e, nil
+}
+
+func (s *LRUStore) Set(ctx context.Context, key string, e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elm := s.keyToElm[key]; elm != nil {
+		s.remove(elm)
+	}
+	for s.items.Len() >= s.capacity {
+		s.remove(s.items.Back())
+	}
+	s.keyToElm[key] = s.items.PushFront(&lruItem{key: key, entry: e})
+	for _, tag := range e.Tags {
+		keys := s.tagToKey[tag]
+		if keys == nil {
+			keys = make(map[string]bool)
+			s.tagToKey[tag] = keys
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
+func (s *LRUStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elm := s.keyToElm[key]; elm != nil {
+		s.remove(elm)
+	}
+	return nil
+}
+
+func (s *LRUStore) DeleteTags(ctx context.Context, tags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range tags {
+		for key := range s.tagToKey[tag] {
+			if elm := s.keyToElm[key]; elm != nil {
+				s.remove(elm)
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries
+func (s *LRUStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.items.Len()
+}
+
+func (s *LRUStore) remove(elm *list.Element) {
+	item := s.items.Remove(elm).(*lruItem)
+	delete(s.keyToElm, item.key)
+	for _, tag := range item.entry.Tags {
+		keys := s.tagToKey[tag]
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(s.tagToKey, tag)
+		}
+	}
+}
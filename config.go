@@ -0,0 +1,366 @@
+package wine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gopub/environ"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is time.Duration which is encoded as string in config files, e.g. "10s"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ByteSize is size in bytes which is encoded as string with unit in config files, e.g. "512", "64KB", "8MB", "1GB"
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	for _, u := range byteUnits[:3] {
+		if b != 0 && int64(b)%u.size == 0 {
+			return []byte(strconv.FormatInt(int64(b)/u.size, 10) + u.suffix), nil
+		}
+	}
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToUpper(strings.TrimSpace(string(text)))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	*b = ByteSize(v * float64(unit))
+	return nil
+}
+
+// UnmarshalJSON accepts number as well as string
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return b.UnmarshalText([]byte(s))
+	}
+	return b.UnmarshalText(data)
+}
+
+// ServerConfig is the configuration of Server. Comments show the default value and the env key
+type ServerConfig struct {
+	// "wsessionid", wine.session.name
+	SessionName string `json:"session_name" yaml:"session_name" toml:"session_name"`
+	// 30m, wine.session.ttl. It must be at least 5 minutes
+	SessionTTL Duration `json:"session_ttl" yaml:"session_ttl" toml:"session_ttl"`
+	// 8MB, wine.max_memory. Max memory of parsing multipart form
+	MaxRequestMemory ByteSize `json:"max_request_memory" yaml:"max_request_memory" toml:"max_request_memory"`
+	// 10s, wine.timeout. Zero means no timeout
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	// true, wine.compression
+	Compression bool `json:"compression" yaml:"compression" toml:"compression"`
+	// 1KB, wine.compression.min_size
+	CompressionMinSize ByteSize `json:"compression_min_size" yaml:"compression_min_size" toml:"compression_min_size"`
+	// empty, which means the default list
+	CompressibleTypes []string `json:"compressible_types,omitempty" yaml:"compressible_types" toml:"compressible_types"`
+	// 32MB, wine.max_decompressed_body_size
+	MaxDecompressedBodySize ByteSize `json:"max_decompressed_body_size" yaml:"max_decompressed_body_size" toml:"max_decompressed_body_size"`
+	// true, wine.recovery
+	Recovery bool `json:"recovery" yaml:"recovery" toml:"recovery"`
+
+	// 0, wine.read_timeout, wine.read_header_timeout, wine.write_timeout and wine.idle_timeout
+	ReadTimeout       Duration `json:"read_timeout" yaml:"read_timeout" toml:"read_timeout"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout" toml:"read_header_timeout"`
+	WriteTimeout      Duration `json:"write_timeout" yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"`
+	// 0, wine.shutdown_delay
+	ShutdownDelay Duration `json:"shutdown_delay" yaml:"shutdown_delay" toml:"shutdown_delay"`
+
+	// empty, wine.trusted_proxies separated by comma, e.g. "10.0.0.0/8,192.168.1.10"
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// false, wine.h2c
+	H2C bool `json:"h2c" yaml:"h2c" toml:"h2c"`
+	// 0 which means 250, wine.http2.max_concurrent_streams
+	HTTP2MaxConcurrentStreams uint32 `json:"http2_max_concurrent_streams" yaml:"http2_max_concurrent_streams" toml:"http2_max_concurrent_streams"`
+	// 0 which means 1MB, wine.http2.max_read_frame_size. It ranges from 16KB to 16MB
+	HTTP2MaxReadFrameSize ByteSize `json:"http2_max_read_frame_size" yaml:"http2_max_read_frame_size" toml:"http2_max_read_frame_size"`
+	// empty, wine.tls.client_ca_files separated by comma
+	ClientCAFiles []string `json:"client_ca_files,omitempty" yaml:"client_ca_files" toml:"client_ca_files"`
+}
+
+// NewServerConfig returns config with default values
+func NewServerConfig() *ServerConfig {
+	return &ServerConfig{
+		SessionName:             "wsessionid",
+		SessionTTL:              Duration(defaultSessionTTL),
+		MaxRequestMemory:        8 << 20,
+		Timeout:                 Duration(10 * time.Second),
+		Compression:             true,
+		CompressionMinSize:      1 << 10,
+		MaxDecompressedBodySize: 32 << 20,
+		Recovery:                true,
+	}
+}
+
+// LoadServerConfig loads config from file whose format is determined by extension: .json, .yaml, .yml or .toml.
+// Fields missing in file are default values, and they are overridden by env, then the config is validated
+func LoadServerConfig(filename string) (*ServerConfig, error) {
+	c := NewServerConfig()
+	if err := c.LoadFile(filename); err != nil {
+		return nil, err
+	}
+	c.LoadEnv()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile decodes file into c. Unknown fields are rejected
+func (c *ServerConfig) LoadFile(filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(c)
+	case ".yaml", ".yml":
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true)
+		err = d.Decode(c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(b), c)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown fields %v", md.Undecoded())
+		}
+	default:
+		return fmt.Errorf("unsupported file format %s", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", filename, err)
+	}
+	return nil
+}
+
+// LoadEnv overrides c with env, e.g. wine.timeout
+func (c *ServerConfig) LoadEnv() {
+	c.SessionName = environ.String("wine.session.name", c.SessionName)
+	c.SessionTTL = Duration(environ.Duration("wine.session.ttl", time.Duration(c.SessionTTL)))
+	// Short ttl in env has always been raised to the minimum, while it's invalid in config file
+	if environ.Has("wine.session.ttl") && time.Duration(c.SessionTTL) < minSessionTTL {
+		logger.Warnf("wine.session.ttl %v is less than %v", time.Duration(c.SessionTTL), minSessionTTL)
+		c.SessionTTL = Duration(minSessionTTL)
+	}
+	c.MaxRequestMemory = ByteSize(environ.SizeInBytes("wine.max_memory", int(c.MaxRequestMemory)))
+	c.Timeout = Duration(environ.Duration("wine.timeout", time.Duration(c.Timeout)))
+	c.Compression = environ.Bool("wine.compression", c.Compression)
+	c.CompressionMinSize = ByteSize(environ.SizeInBytes("wine.compression.min_size", int(c.CompressionMinSize)))
+	c.MaxDecompressedBodySize = ByteSize(environ.SizeInBytes("wine.max_decompressed_body_size", int(c.MaxDecompressedBodySize)))
+	c.Recovery = environ.Bool("wine.recovery", c.Recovery)
+	c.ReadTimeout = Duration(environ.Duration("wine.read_timeout", time.Duration(c.ReadTimeout)))
+	c.ReadHeaderTimeout = Duration(environ.Duration("wine.read_header_timeout", time.Duration(c.ReadHeaderTimeout)))
+	c.WriteTimeout = Duration(environ.Duration("wine.write_timeout", time.Duration(c.WriteTimeout)))
+	c.IdleTimeout = Duration(environ.Duration("wine.idle_timeout", time.Duration(c.IdleTimeout)))
+	c.ShutdownDelay = Duration(environ.Duration("wine.shutdown_delay", time.Duration(c.ShutdownDelay)))
+	if v := environ.String("wine.trusted_proxies", ""); v != "" {
+		c.TrustedProxies = strings.Split(v, ",")
+	}
+	c.H2C = environ.Bool("wine.h2c", c.H2C)
+	c.HTTP2MaxConcurrentStreams = uint32(environ.Int("wine.http2.max_concurrent_streams", int(c.HTTP2MaxConcurrentStreams)))
+	c.HTTP2MaxReadFrameSize = ByteSize(environ.SizeInBytes("wine.http2.max_read_frame_size", int(c.HTTP2MaxReadFrameSize)))
+	if v := environ.String("wine.tls.client_ca_files", ""); v != "" {
+		c.ClientCAFiles = strings.Split(v, ",")
+	}
+}
+
+// Validate returns error of the first invalid field
+func (c *ServerConfig) Validate() error {
+	if c.SessionName == "" {
+		return errors.New("session_name is empty")
+	}
+	if time.Duration(c.SessionTTL) < minSessionTTL {
+		return fmt.Errorf("session_ttl %v is less than %v", time.Duration(c.SessionTTL), minSessionTTL)
+	}
+	if c.MaxRequestMemory <= 0 {
+		return errors.New("max_request_memory must be positive")
+	}
+	if c.CompressionMinSize < 0 {
+		return errors.New("compression_min_size is negative")
+	}
+	if c.MaxDecompressedBodySize <= 0 {
+		return errors.New("max_decompressed_body_size must be positive")
+	}
+	for name, d := range map[string]Duration{
+		"timeout":             c.Timeout,
+		"read_timeout":        c.ReadTimeout,
+		"read_header_timeout": c.ReadHeaderTimeout,
+		"write_timeout":       c.WriteTimeout,
+		"idle_timeout":        c.IdleTimeout,
+		"shutdown_delay":      c.ShutdownDelay,
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s is negative", name)
+		}
+	}
+	if _, err := ParseCIDRs(c.TrustedProxies...); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	if s := c.HTTP2MaxReadFrameSize; s != 0 && (s < 16<<10 || s > 16<<20) {
+		return fmt.Errorf("http2_max_read_frame_size %d is out of range [16KB, 16MB]", s)
+	}
+	for _, f := range c.ClientCAFiles {
+		if _, err := ioutil.ReadFile(f); err != nil {
+			return fmt.Errorf("client_ca_files: %w", err)
+		}
+	}
+	return nil
+}
+
+// ServerOption sets config in NewServer
+type ServerOption func(c *ServerConfig)
+
+// WithConfig replaces config loaded from env with c
+func WithConfig(c *ServerConfig) ServerOption {
+	return func(dst *ServerConfig) {
+		*dst = *c
+	}
+}
+
+func WithSession(name string, ttl time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.SessionName = name
+		c.SessionTTL = Duration(ttl)
+	}
+}
+
+func WithMaxRequestMemory(size int64) ServerOption {
+	return func(c *ServerConfig) {
+		c.MaxRequestMemory = ByteSize(size)
+	}
+}
+
+func WithTimeout(timeout time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.Timeout = Duration(timeout)
+	}
+}
+
+func WithCompression(enabled bool, minSize int64) ServerOption {
+	return func(c *ServerConfig) {
+		c.Compression = enabled
+		c.CompressionMinSize = ByteSize(minSize)
+	}
+}
+
+func WithRecovery(enabled bool) ServerOption {
+	return func(c *ServerConfig) {
+		c.Recovery = enabled
+	}
+}
+
+// WithHTTPTimeouts sets timeouts of the underlying http.Server
+func WithHTTPTimeouts(read, readHeader, write, idle time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.ReadTimeout = Duration(read)
+		c.ReadHeaderTimeout = Duration(readHeader)
+		c.WriteTimeout = Duration(write)
+		c.IdleTimeout = Duration(idle)
+	}
+}
+
+func WithShutdownDelay(delay time.Duration) ServerOption {
+	return func(c *ServerConfig) {
+		c.ShutdownDelay = Duration(delay)
+	}
+}
+
+func WithTrustedProxies(cidrs ...string) ServerOption {
+	return func(c *ServerConfig) {
+		c.TrustedProxies = cidrs
+	}
+}
+
+func WithH2C(enabled bool) ServerOption {
+	return func(c *ServerConfig) {
+		c.H2C = enabled
+	}
+}
+
+func WithClientCAFiles(files ...string) ServerOption {
+	return func(c *ServerConfig) {
+		c.ClientCAFiles = files
+	}
+}
+
+// Config returns effective config of s
+func (s *Server) Config() *ServerConfig {
+	var proxies []string
+	for _, n := range s.TrustedProxies {
+		proxies = append(proxies, n.String())
+	}
+	return &ServerConfig{
+		SessionName:               s.sessionName,
+		SessionTTL:                Duration(s.sessionTTL),
+		MaxRequestMemory:          ByteSize(s.maxRequestMemory),
+		Timeout:                   Duration(s.Timeout),
+		Compression:               s.CompressionEnabled,
+		CompressionMinSize:        ByteSize(s.CompressionMinSize),
+		CompressibleTypes:         s.CompressibleTypes,
+		MaxDecompressedBodySize:   ByteSize(s.MaxDecompressedBodySize),
+		Recovery:                  s.Recovery,
+		ReadTimeout:               Duration(s.ReadTimeout),
+		ReadHeaderTimeout:         Duration(s.ReadHeaderTimeout),
+		WriteTimeout:              Duration(s.WriteTimeout),
+		IdleTimeout:               Duration(s.IdleTimeout),
+		ShutdownDelay:             Duration(s.ShutdownDelay),
+		TrustedProxies:            proxies,
+		H2C:                       s.HTTP2.H2C,
+		HTTP2MaxConcurrentStreams: s.HTTP2.MaxConcurrentStreams,
+		HTTP2MaxReadFrameSize:     ByteSize(s.HTTP2.MaxReadFrameSize),
+		ClientCAFiles:             s.clientCAFiles,
+	}
+}
@@ -0,0 +1,144 @@
+package wine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testConfigJSON = `{
+	"session_name": "sid",
+	"session_ttl": "1h",
+	"max_request_memory": 1048576,
+	"timeout": "30s",
+	"compression": false,
+	"trusted_proxies": ["10.0.0.0/8"],
+	"http2_max_read_frame_size": "256KB"
+}`
+	testConfigYAML = `
+session_name: sid
+session_ttl: 1h
+max_request_memory: 1MB
+timeout: 30s
+compression: false
+trusted_proxies:
+  - 10.0.0.0/8
+http2_max_read_frame_size: 256KB
+`
+	testConfigTOML = `
+session_name = "sid"
+session_ttl = "1h"
+max_request_memory = "1MB"
+timeout = "30s"
+compression = false
+trusted_proxies = ["10.0.0.0/8"]
+http2_max_read_frame_size = "256KB"
+`
+)
+
+func TestServerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+		return filename
+	}
+
+	t.Run("Load", func(t *testing.T) {
+		expected := wine.NewServerConfig()
+		expected.SessionName = "sid"
+		expected.SessionTTL = wine.Duration(time.Hour)
+		expected.MaxRequestMemory = 1 << 20
+		expected.Timeout = wine.Duration(30 * time.Second)
+		expected.Compression = false
+		expected.TrustedProxies = []string{"10.0.0.0/8"}
+		expected.HTTP2MaxReadFrameSize = 256 << 10
+		for name, content := range map[string]string{
+			"wine.json": testConfigJSON,
+			"wine.yaml": testConfigYAML,
+			"wine.toml": testConfigTOML,
+		} {
+			c, err := wine.LoadServerConfig(write(name, content))
+			require.NoError(t, err, name)
+			require.Equal(t, expected, c, name)
+		}
+	})
+	t.Run("UnknownField", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"unknown.json": `{"timeout": "1s", "timout": "2s"}`,
+			"unknown.yaml": "timout: 2s",
+			"unknown.toml": `timout = "2s"`,
+		} {
+			_, err := wine.LoadServerConfig(write(name, content))
+			require.Error(t, err, name)
+		}
+		_, err := wine.LoadServerConfig(write("wine.ini", ""))
+		require.Error(t, err)
+	})
+	t.Run("Validate", func(t *testing.T) {
+		for name, set := range map[string]func(c *wine.ServerConfig){
+			"SessionTTL":     func(c *wine.ServerConfig) { c.SessionTTL = wine.Duration(time.Minute) },
+			"Timeout":        func(c *wine.ServerConfig) { c.Timeout = -1 },
+			"TrustedProxies": func(c *wine.ServerConfig) { c.TrustedProxies = []string{"10.0.0.0/33"} },
+			"FrameSize":      func(c *wine.ServerConfig) { c.HTTP2MaxReadFrameSize = 1024 },
+			"ClientCAFiles":  func(c *wine.ServerConfig) { c.ClientCAFiles = []string{filepath.Join(dir, "none.crt")} },
+		} {
+			c := wine.NewServerConfig()
+			require.NoError(t, c.Validate())
+			set(c)
+			require.Error(t, c.Validate(), name)
+		}
+	})
+	t.Run("EnvSessionTTL", func(t *testing.T) {
+		// environ reads env variable of the uppercased key
+		t.Setenv("WINE.SESSION.TTL", "1m")
+		s := wine.NewServer()
+		require.Equal(t, wine.Duration(5*time.Minute), s.Config().SessionTTL)
+	})
+	t.Run("ByteSize", func(t *testing.T) {
+		for text, size := range map[string]wine.ByteSize{
+			"512":   512,
+			"64KB":  64 << 10,
+			"8mb":   8 << 20,
+			"1.5G":  3 << 29,
+			"100 B": 100,
+		} {
+			var b wine.ByteSize
+			require.NoError(t, b.UnmarshalText([]byte(text)), text)
+			require.Equal(t, size, b, text)
+		}
+		text, err := wine.ByteSize(8 << 20).MarshalText()
+		require.NoError(t, err)
+		require.Equal(t, "8MB", string(text))
+		var b wine.ByteSize
+		require.Error(t, b.UnmarshalText([]byte("8XB")))
+	})
+	t.Run("Options", func(t *testing.T) {
+		s := wine.NewServer(
+			wine.WithSession("sid", time.Hour),
+			wine.WithMaxRequestMemory(1<<20),
+			wine.WithTimeout(time.Minute),
+			wine.WithTrustedProxies("192.168.0.0/16"),
+		)
+		c := s.Config()
+		require.Equal(t, "sid", c.SessionName)
+		require.Equal(t, wine.Duration(time.Hour), c.SessionTTL)
+		require.Equal(t, wine.ByteSize(1<<20), c.MaxRequestMemory)
+		require.Equal(t, time.Minute, s.Timeout)
+		require.Equal(t, []string{"192.168.0.0/16"}, c.TrustedProxies)
+
+		expected := wine.NewServerConfig()
+		expected.Compression = false
+		s = wine.NewServer(wine.WithConfig(expected), wine.WithRecovery(false))
+		expected.Recovery = false
+		require.Equal(t, expected, s.Config())
+	})
+}
@@ -22,6 +22,10 @@ const (
 	ckTraceID
 	ckUser
 	ckDeviceID
+	ckHTTPRequest
+	ckScheme
+	ckHost
+	ckPeerIdentity
 )
 
 func GetBasicAuthUser(ctx context.Context) string {
@@ -52,6 +56,15 @@ func withResponseWriter(ctx context.Context, rw http.ResponseWriter) context.Con
 	return context.WithValue(ctx, ckHTTPResponseWriter, rw)
 }
 
+func getHTTPRequest(ctx context.Context) *http.Request {
+	req, _ := ctx.Value(ckHTTPRequest).(*http.Request)
+	return req
+}
+
+func withHTTPRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, ckHTTPRequest, req)
+}
+
 // GetTemplates returns templates in context
 func GetTemplates(ctx context.Context) []*template.Template {
 	v, _ := ctx.Value(ckTemplates).([]*template.Template)
@@ -106,6 +119,32 @@ func WithRemoteAddr(ctx context.Context, addr string) context.Context {
 	return context.WithValue(ctx, ckRemoteAddr, addr)
 }
 
+// GetScheme returns scheme requested by client, which may be forwarded by trusted proxies
+func GetScheme(ctx context.Context) string {
+	scheme, _ := ctx.Value(ckScheme).(string)
+	return scheme
+}
+
+func withScheme(ctx context.Context, scheme string) context.Context {
+	if scheme == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ckScheme, scheme)
+}
+
+// GetHost returns host requested by client, which may be forwarded by trusted proxies
+func GetHost(ctx context.Context) string {
+	host, _ := ctx.Value(ckHost).(string)
+	return host
+}
+
+func withHost(ctx context.Context, host string) context.Context {
+	if host == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ckHost, host)
+}
+
 func GetDeviceID(ctx context.Context) string {
 	id, _ := ctx.Value(ckDeviceID).(string)
 	return id
@@ -145,13 +184,13 @@ func WithLocation(ctx context.Context, location *types.Point) context.Context {
 func DetachContext(ctx context.Context) context.Context {
 	newCtx := context.Background()
 	if t := GetTemplates(ctx); len(t) != 0 {
-		newCtx = withTemplate(ctx, t)
+		newCtx = withTemplate(newCtx, t)
 	}
 	if u := GetBasicAuthUser(ctx); u != "" {
-		newCtx = withBasicAuthUser(ctx, u)
+		newCtx = withBasicAuthUser(newCtx, u)
 	}
 	if sid := GetSessionID(ctx); sid != "" {
-		newCtx = withSessionID(ctx, sid)
+		newCtx = withSessionID(newCtx, sid)
 	}
 	if token := GetAccessToken(ctx); token != "" {
 		newCtx = WithAccessToken(newCtx, token)
@@ -165,6 +204,12 @@ func DetachContext(ctx context.Context) context.Context {
 	if addr := GetRemoteAddr(ctx); addr != "" {
 		newCtx = WithRemoteAddr(newCtx, addr)
 	}
+	if scheme := GetScheme(ctx); scheme != "" {
+		newCtx = withScheme(newCtx, scheme)
+	}
+	if host := GetHost(ctx); host != "" {
+		newCtx = withHost(newCtx, host)
+	}
 	if traceID := GetTraceID(ctx); traceID != "" {
 		newCtx = WithTraceID(newCtx, traceID)
 	}
@@ -174,5 +219,8 @@ func DetachContext(ctx context.Context) context.Context {
 	if u := GetUser(ctx); u != nil {
 		newCtx = WithUser(newCtx, u)
 	}
+	if id := GetPeerIdentity(ctx); id != nil {
+		newCtx = withPeerIdentity(newCtx, id)
+	}
 	return newCtx
 }
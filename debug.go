@@ -0,0 +1,204 @@
+package wine
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rtdebug "runtime/debug"
+	rtpprof "runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopub/types"
+	"github.com/gopub/wine/mime"
+)
+
+const debugPath = "_debug"
+
+var startedAt = time.Now()
+
+// EnableDebug binds _debug/* endpoints which are guarded by guards, e.g. NewBasicAuthHandler or acl.Filter.
+// It must be called before running server, as route statistics are collected since then
+//
+//	_debug/endpoints     routes and handlers
+//	_debug/routes        requests, errors, in-flight requests and latency of routes
+//	_debug/echo          dump of request
+//	_debug/pprof/        pprof index, profiles are read by `go tool pprof http://host/_debug/pprof/profile`
+//	_debug/goroutines    stack traces of all goroutines
+//	_debug/build         build info of executable
+//	_debug/runtime       runtime and memory statistics
+//	_debug/config        effective configuration of server
+func (s *Server) EnableDebug(guards ...Handler) {
+	if len(guards) == 0 {
+		logger.Warnf("Debug endpoints are not guarded")
+	}
+	s.routeStats = new(routeStatsMap)
+	r := s.Group(debugPath).UseHandlers(guards...)
+	r.Get("endpoints", s.listEndpoints)
+	r.Get("routes", s.listRouteStats)
+	r.Any("echo", handleEcho)
+	r.Get("pprof", handlePprof)
+	r.Get("pprof/{name}", handlePprof)
+	r.Get("goroutines", handleGoroutines)
+	r.Get("build", handleBuildInfo)
+	r.Get("runtime", handleRuntime)
+	r.Get("config", s.handleConfig)
+}
+
+func handlePprof(ctx context.Context, req *Request, next Invoker) Responder {
+	var h http.Handler
+	switch name := req.Params().String("name"); name {
+	case "":
+		h = http.HandlerFunc(pprof.Index)
+	case "cmdline":
+		h = http.HandlerFunc(pprof.Cmdline)
+	case "profile":
+		h = http.HandlerFunc(pprof.Profile)
+	case "symbol":
+		h = http.HandlerFunc(pprof.Symbol)
+	case "trace":
+		h = http.HandlerFunc(pprof.Trace)
+	default:
+		h = pprof.Handler(name)
+	}
+	return Handle(req.Request(), h)
+}
+
+func handleGoroutines(ctx context.Context, req *Request, next Invoker) Responder {
+	return ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
+		w.Header().Set(mime.ContentType, mime.PlainUTF8)
+		if err := rtpprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+			logger.Errorf("Write goroutines: %v", err)
+		}
+	})
+}
+
+func handleBuildInfo(ctx context.Context, req *Request, next Invoker) Responder {
+	info, ok := rtdebug.ReadBuildInfo()
+	if !ok {
+		return Text(http.StatusNotFound, "No build info")
+	}
+	deps := make(types.M, len(info.Deps))
+	for _, d := range info.Deps {
+		if d.Replace != nil {
+			deps[d.Path] = d.Replace.Path + "@" + d.Replace.Version
+		} else {
+			deps[d.Path] = d.Version
+		}
+	}
+	settings := make(types.M, len(info.Settings))
+	for _, st := range info.Settings {
+		settings[st.Key] = st.Value
+	}
+	return JSON(http.StatusOK, types.M{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"version":    info.Main.Version,
+		"settings":   settings,
+		"deps":       deps,
+	})
+}
+
+func handleRuntime(ctx context.Context, req *Request, next Invoker) Responder {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return JSON(http.StatusOK, types.M{
+		"uptime":     time.Since(startedAt).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"num_cpu":    runtime.NumCPU(),
+		"memory": types.M{
+			"alloc":          m.Alloc,
+			"total_alloc":    m.TotalAlloc,
+			"sys":            m.Sys,
+			"heap_alloc":     m.HeapAlloc,
+			"heap_objects":   m.HeapObjects,
+			"num_gc":         m.NumGC,
+			"pause_total_ns": m.PauseTotalNs,
+		},
+	})
+}
+
+func (s *Server) handleConfig(ctx context.Context, req *Request, next Invoker) Responder {
+	return JSON(http.StatusOK, &struct {
+		*ServerConfig
+		ClientAuth   string   `json:"client_auth"`
+		HealthChecks []string `json:"health_checks"`
+	}{
+		ServerConfig: s.Config(),
+		ClientAuth:   s.ClientAuth.String(),
+		HealthChecks: s.Health.Names(),
+	})
+}
+
+// routeStats is accessed atomically
+type routeStats struct {
+	requests int64
+	errors   int64
+	inFlight int64
+	// total and max latency in nanoseconds
+	totalLatency int64
+	maxLatency   int64
+}
+
+func (st *routeStats) begin() {
+	atomic.AddInt64(&st.inFlight, 1)
+}
+
+func (st *routeStats) end(status int, latency time.Duration) {
+	atomic.AddInt64(&st.inFlight, -1)
+	atomic.AddInt64(&st.requests, 1)
+	if status >= http.StatusInternalServerError {
+		atomic.AddInt64(&st.errors, 1)
+	}
+	atomic.AddInt64(&st.totalLatency, int64(latency))
+	for {
+		max := atomic.LoadInt64(&st.maxLatency)
+		if int64(latency) <= max || atomic.CompareAndSwapInt64(&st.maxLatency, max, int64(latency)) {
+			break
+		}
+	}
+}
+
+// routeStatsMap is keyed by handler list of route
+type routeStatsMap struct {
+	m sync.Map
+}
+
+func (m *routeStatsMap) get(handlers *list.List) *routeStats {
+	if v, ok := m.m.Load(handlers); ok {
+		return v.(*routeStats)
+	}
+	v, _ := m.m.LoadOrStore(handlers, new(routeStats))
+	return v.(*routeStats)
+}
+
+func (s *Server) listRouteStats(ctx context.Context, req *Request, next Invoker) Responder {
+	l, nodeToMethod := s.endpoints()
+	res := make([]types.M, 0, len(l))
+	for _, n := range l {
+		v, ok := s.routeStats.m.Load(n.Handlers())
+		if !ok {
+			continue
+		}
+		st := v.(*routeStats)
+		requests := atomic.LoadInt64(&st.requests)
+		var avg time.Duration
+		if requests > 0 {
+			avg = time.Duration(atomic.LoadInt64(&st.totalLatency) / requests)
+		}
+		res = append(res, types.M{
+			"method":      nodeToMethod[n],
+			"path":        "/" + n.Path(),
+			"requests":    requests,
+			"errors":      atomic.LoadInt64(&st.errors),
+			"in_flight":   atomic.LoadInt64(&st.inFlight),
+			"avg_latency": avg.String(),
+			"max_latency": time.Duration(atomic.LoadInt64(&st.maxLatency)).String(),
+		})
+	}
+	return JSON(http.StatusOK, res)
+}
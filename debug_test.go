@@ -0,0 +1,117 @@
+package wine_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebug(t *testing.T) {
+	s := wine.NewServer()
+	s.Get("items/{id}", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		if req.Params().String("id") == "0" {
+			return wine.Status(http.StatusInternalServerError)
+		}
+		return wine.Text(http.StatusOK, "item")
+	})
+	s.Health.Register("db", func(ctx context.Context) error {
+		return nil
+	}, nil)
+	s.EnableDebug(wine.NewBasicAuthHandler(map[string]string{"admin": "123"}, ""))
+	addr := runServer(t, s)
+	defer s.Shutdown()
+
+	get := func(path string, auth bool) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/"+path, nil)
+		require.NoError(t, err)
+		if auth {
+			req.SetBasicAuth("admin", "123")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp.StatusCode, readBody(t, resp)
+	}
+
+	t.Run("Disabled", func(t *testing.T) {
+		s := wine.NewServer()
+		addr := runServer(t, s)
+		defer s.Shutdown()
+		resp, err := http.Get("http://" + addr + "/_debug/echo")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("Unauthorized", func(t *testing.T) {
+		status, _ := get("_debug/endpoints", false)
+		require.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("Endpoints", func(t *testing.T) {
+		status, body := get("_debug/endpoints", true)
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, strings.Contains(body, "/items/{id}"))
+	})
+	t.Run("Routes", func(t *testing.T) {
+		for _, id := range []string{"0", "1", "2"} {
+			get("items/"+id, false)
+		}
+		status, body := get("_debug/routes", true)
+		require.Equal(t, http.StatusOK, status)
+		var routes []struct {
+			Method   string `json:"method"`
+			Path     string `json:"path"`
+			Requests int    `json:"requests"`
+			Errors   int    `json:"errors"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &routes))
+		found := false
+		for _, r := range routes {
+			if r.Path == "/items/{id}" {
+				found = true
+				require.Equal(t, http.MethodGet, r.Method)
+				require.Equal(t, 3, r.Requests)
+				require.Equal(t, 1, r.Errors)
+			}
+		}
+		require.True(t, found)
+	})
+	t.Run("Pprof", func(t *testing.T) {
+		status, body := get("_debug/pprof/", true)
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, strings.Contains(body, "goroutine"))
+		status, body = get("_debug/pprof/heap?debug=1", true)
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, strings.Contains(body, "heap profile"))
+	})
+	t.Run("Goroutines", func(t *testing.T) {
+		status, body := get("_debug/goroutines", true)
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, strings.Contains(body, "goroutine "))
+	})
+	t.Run("Runtime", func(t *testing.T) {
+		status, body := get("_debug/runtime", true)
+		require.Equal(t, http.StatusOK, status)
+		var v map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(body), &v))
+		require.NotEmpty(t, v["goroutines"])
+	})
+	t.Run("Build", func(t *testing.T) {
+		status, body := get("_debug/build", true)
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, strings.Contains(body, "go_version"))
+	})
+	t.Run("Config", func(t *testing.T) {
+		status, body := get("_debug/config", true)
+		require.Equal(t, http.StatusOK, status)
+		var v map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(body), &v))
+		require.Equal(t, s.Timeout.String(), v["timeout"])
+		require.Equal(t, s.ClientAuth.String(), v["client_auth"])
+		require.Equal(t, []interface{}{"db"}, v["health_checks"])
+	})
+}
@@ -0,0 +1,131 @@
+package wine
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NewETagHandler returns a handler which computes ETag for *Response and evaluates If-None-Match and If-Match
+// for GET and HEAD requests. Weak ETag is suggested if compression is enabled, as compressed content differs from the
+// original one. cacheControl will be set as Cache-Control header if it's not empty, e.g. "no-cache", "private, max-age=60".
+// Preconditions of unsafe requests are evaluated by NewPreconditionHandler, as they must be checked before the update
+func NewETagHandler(weak bool, cacheControl string) HandlerFunc {
+	return func(ctx context.Context, req *Request, next Invoker) Responder {
+		resp := next(ctx, req)
+		r, ok := resp.(*Response)
+		if !ok {
+			return resp
+		}
+		if cacheControl != "" && r.header.Get("Cache-Control") == "" {
+			r.header.Set("Cache-Control", cacheControl)
+		}
+
+		method := req.request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			return r
+		}
+		if r.status < http.StatusOK || r.status >= http.StatusMultipleChoices {
+			return r
+		}
+
+		etag := r.header.Get("ETag")
+		if etag == "" {
+			etag = computeETag(r.getBytes(), weak)
+			r.header.Set("ETag", etag)
+		}
+
+		h := req.request.Header
+		if im := h.Get("If-Match"); im != "" && !matchETag(im, etag, false) {
+			return Status(http.StatusPreconditionFailed)
+		}
+		if inm := h.Get("If-None-Match"); inm != "" && matchETag(inm, etag, true) {
+			return notModified(r.header)
+		}
+		return r
+	}
+}
+
+// Validator returns ETag and last modification time of the current representation of requested resource.
+// etag is empty if the resource doesn't exist
+type Validator func(ctx context.Context, req *Request) (etag string, modTime time.Time, err error)
+
+// NewPreconditionHandler returns a handler which evaluates If-Match and If-Unmodified-Since of requests other than
+// GET and HEAD, e.g. PUT, PATCH and DELETE, against the current representation before calling next.
+// It responds 412 if the precondition fails, which prevents lost updates
+func NewPreconditionHandler(validator Validator) HandlerFunc {
+	return func(ctx context.Context, req *Request, next Invoker) Responder {
+		method := req.request.Method
+		if method == http.MethodGet || method == http.MethodHead {
+			return next(ctx, req)
+		}
+		h := req.request.Header
+		im := h.Get("If-Match")
+		ius := h.Get("If-Unmodified-Since")
+		if im == "" && ius == "" {
+			return next(ctx, req)
+		}
+		etag, modTime, err := validator(ctx, req)
+		if err != nil {
+			logger.Errorf("Validate %s: %v", req.request.URL.Path, err)
+			return Status(http.StatusInternalServerError)
+		}
+		if im != "" {
+			if etag == "" || !matchETag(im, etag, false) {
+				return Status(http.StatusPreconditionFailed)
+			}
+		} else if t, err := http.ParseTime(ius); err == nil && !modTime.IsZero() {
+			if modTime.Truncate(time.Second).After(t) {
+				return Status(http.StatusPreconditionFailed)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+func computeETag(b []byte, weak bool) string {
+	sum := sha1.Sum(b)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// matchETag reports whether etag matches one of the comma separated tags in list.
+// Weak comparison ignores W/ prefix, strong comparison requires both tags are strong
+func matchETag(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(tag, "W/") && tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func notModified(header http.Header) Responder {
+	h := make(http.Header)
+	for _, k := range []string{"ETag", "Cache-Control", "Expires", "Vary", "Content-Location"} {
+		k = http.CanonicalHeaderKey(k)
+		if v, ok := header[k]; ok {
+			h[k] = v
+		}
+	}
+	return ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
+		for k, v := range h {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(http.StatusNotModified)
+	})
+}
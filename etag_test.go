@@ -0,0 +1,105 @@
+package wine_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETagHandler(t *testing.T) {
+	s := wine.NewServer()
+	r := s.Group("items").UseHandlers(wine.NewETagHandler(true, "private, max-age=60"))
+	r.Get("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.JSON(http.StatusOK, []string{"a", "b"})
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	get := func(header http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/items", nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get(nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, "W/", etag[:2])
+	assert.Equal(t, "private, max-age=60", resp.Header.Get("Cache-Control"))
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		resp := get(http.Header{"If-None-Match": {`"other", ` + etag}})
+		require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, etag, resp.Header.Get("ETag"))
+		assert.Equal(t, "private, max-age=60", resp.Header.Get("Cache-Control"))
+
+		resp = get(http.Header{"If-None-Match": {`"other"`}})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("IfMatch", func(t *testing.T) {
+		// Weak ETag never matches in strong comparison
+		resp := get(http.Header{"If-Match": {etag}})
+		require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+		resp = get(http.Header{"If-Match": {"*"}})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestPreconditionHandler(t *testing.T) {
+	etag := `"v1"`
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var updates int
+	s := wine.NewServer()
+	r := s.Group("items").UseHandlers(wine.NewPreconditionHandler(func(ctx context.Context, req *wine.Request) (string, time.Time, error) {
+		return etag, modTime, nil
+	}))
+	r.Put("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		updates++
+		etag = `"v2"`
+		return wine.Status(http.StatusNoContent)
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	put := func(header http.Header) int {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/items", nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("IfUnmodifiedSince", func(t *testing.T) {
+		require.Equal(t, http.StatusPreconditionFailed, put(http.Header{"If-Unmodified-Since": {modTime.Add(-time.Second).Format(http.TimeFormat)}}))
+		require.Equal(t, 0, updates)
+	})
+
+	t.Run("IfMatch", func(t *testing.T) {
+		require.Equal(t, http.StatusPreconditionFailed, put(http.Header{"If-Match": {`W/"v1"`}}))
+		require.Equal(t, http.StatusNoContent, put(http.Header{"If-Match": {`"v1"`}}))
+		// The other client updating with the old ETag loses
+		require.Equal(t, http.StatusPreconditionFailed, put(http.Header{"If-Match": {`"v1"`}}))
+		require.Equal(t, 1, updates)
+		require.Equal(t, http.StatusNoContent, put(nil))
+		require.Equal(t, 2, updates)
+	})
+}
@@ -0,0 +1,226 @@
+package wine
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Forwarding headers set by proxies
+const (
+	HeaderForwarded       = "Forwarded"
+	HeaderXForwardedFor   = "X-Forwarded-For"
+	HeaderXForwardedHost  = "X-Forwarded-Host"
+	HeaderXForwardedProto = "X-Forwarded-Proto"
+	HeaderXRealIP         = "X-Real-IP"
+)
+
+// KeyFunc returns the key which requests are grouped by, e.g. for rate limiting
+type KeyFunc func(ctx context.Context, req *Request) string
+
+// ClientIPKey is the default KeyFunc, which groups requests by client IP
+func ClientIPKey(ctx context.Context, req *Request) string {
+	if ip := GetRemoteAddr(ctx); ip != "" {
+		return ip
+	}
+	return remoteIP(req.Request())
+}
+
+// ParseCIDRs parses CIDRs, e.g. 10.0.0.0/8. A single IP is treated as a CIDR which contains only itself
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse cidr %s: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// clientInfo is the origin of a request, which may be forwarded by proxies
+type clientInfo struct {
+	IP     string
+	Scheme string
+	Host   string
+}
+
+func withClientInfo(ctx context.Context, c clientInfo) context.Context {
+	ctx = WithRemoteAddr(ctx, c.IP)
+	ctx = withScheme(ctx, c.Scheme)
+	return withHost(ctx, c.Host)
+}
+
+func containsIP(nets []*net.IPNet, ip string) bool {
+	v := net.ParseIP(ip)
+	if v == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClient returns client info of req. Forwarding headers are used only if req is sent by a trusted proxy.
+// Forwarded takes precedence over X-Forwarded-For, which takes precedence over X-Real-IP
+func resolveClient(req *http.Request, trustedProxies []*net.IPNet) clientInfo {
+	c := clientInfo{
+		IP:     remoteIP(req),
+		Scheme: "http",
+		Host:   req.Host,
+	}
+	if req.TLS != nil {
+		c.Scheme = "https"
+	}
+	if !containsIP(trustedProxies, c.IP) {
+		return c
+	}
+	if elems := parseForwarded(req.Header.Values(HeaderForwarded)); len(elems) > 0 {
+		// The rightmost untrusted address is the client, as leftmost values can be forged by client
+		e := elems[0]
+		for i := len(elems) - 1; i >= 0; i-- {
+			if !containsIP(trustedProxies, elems[i]["for"]) {
+				e = elems[i]
+				break
+			}
+		}
+		if ip := e["for"]; net.ParseIP(ip) != nil {
+			c.IP = ip
+		}
+		if proto := e["proto"]; proto == "http" || proto == "https" {
+			c.Scheme = proto
+		}
+		if host := e["host"]; host != "" {
+			c.Host = host
+		}
+		return c
+	}
+	ips := splitHeader(req.Header.Values(HeaderXForwardedFor))
+	// hop is index of client in X-Forwarded-For
+	hop := -1
+	if len(ips) > 0 {
+		hop = 0
+		for i := len(ips) - 1; i >= 0; i-- {
+			if !containsIP(trustedProxies, ips[i]) {
+				hop = i
+				break
+			}
+		}
+		if net.ParseIP(ips[hop]) != nil {
+			c.IP = ips[hop]
+		}
+	} else if ip := strings.TrimSpace(req.Header.Get(HeaderXRealIP)); net.ParseIP(ip) != nil {
+		c.IP = ip
+	}
+	if proto := forwardedValue(req.Header.Values(HeaderXForwardedProto), len(ips), hop); proto == "http" || proto == "https" {
+		c.Scheme = proto
+	}
+	if host := forwardedValue(req.Header.Values(HeaderXForwardedHost), len(ips), hop); host != "" {
+		c.Host = host
+	}
+	return c
+}
+
+// forwardedValue returns value of X-Forwarded-Proto or X-Forwarded-Host appended by the same hop as the client in
+// X-Forwarded-For with n addresses. Otherwise the rightmost value is returned, as leftmost values can be forged by client
+func forwardedValue(values []string, n, hop int) string {
+	l := splitHeader(values)
+	if len(l) == 0 {
+		return ""
+	}
+	if hop >= 0 && len(l) == n {
+		return l[hop]
+	}
+	return l[len(l)-1]
+}
+
+func splitHeader(values []string) []string {
+	var l []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				l = append(l, s)
+			}
+		}
+	}
+	return l
+}
+
+// parseForwarded parses RFC 7239 Forwarded header, e.g. for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8::1]:80"
+func parseForwarded(values []string) []map[string]string {
+	var elems []map[string]string
+	for _, v := range values {
+		for _, s := range splitQuoted(v, ',') {
+			e := make(map[string]string)
+			for _, pair := range splitQuoted(s, ';') {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					continue
+				}
+				k := strings.ToLower(strings.TrimSpace(pair[:i]))
+				e[k] = strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+			}
+			if ip, ok := e["for"]; ok {
+				e["for"] = parseNodeIP(ip)
+			}
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// parseNodeIP returns IP of a node, e.g. 192.0.2.43:47011, [2001:db8::1]:80
+func parseNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			return node[1:i]
+		}
+		return node
+	}
+	if strings.Count(node, ":") == 1 {
+		return node[:strings.IndexByte(node, ':')]
+	}
+	return node
+}
+
+// splitQuoted splits s by sep which isn't in quotes
+func splitQuoted(s string, sep byte) []string {
+	var l []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				l = append(l, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(l, s[start:])
+}
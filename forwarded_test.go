@@ -0,0 +1,105 @@
+package wine_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+func newClientServer(t *testing.T, trustedProxies ...string) *httptest.Server {
+	s := wine.NewServer()
+	nets, err := wine.ParseCIDRs(trustedProxies...)
+	require.NoError(t, err)
+	s.TrustedProxies = nets
+	s.Get("client", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, wine.GetRemoteAddr(ctx)+" "+wine.GetScheme(ctx)+" "+wine.GetHost(ctx))
+	})
+	return httptest.NewServer(s)
+}
+
+func TestClientResolution(t *testing.T) {
+	get := func(ts *httptest.Server, header http.Header) string {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/client", nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	untrusted := newClientServer(t)
+	defer untrusted.Close()
+	ts := newClientServer(t, "127.0.0.1", "10.0.0.0/8")
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	t.Run("Untrusted", func(t *testing.T) {
+		require.Equal(t, "127.0.0.1 http "+strings.TrimPrefix(untrusted.URL, "http://"), get(untrusted, http.Header{
+			"X-Forwarded-For":   {"1.2.3.4"},
+			"X-Forwarded-Proto": {"https"},
+		}))
+	})
+
+	t.Run("XForwardedFor", func(t *testing.T) {
+		require.Equal(t, "1.2.3.4 https example.com", get(ts, http.Header{
+			// Leftmost value is forged by client
+			"X-Forwarded-For":   {"9.9.9.9, 1.2.3.4", "10.0.0.1"},
+			"X-Forwarded-Proto": {"https"},
+			"X-Forwarded-Host":  {"example.com"},
+		}))
+	})
+
+	t.Run("SpoofedProtoAndHost", func(t *testing.T) {
+		// Client sends forged values, which are followed by values appended by the trusted proxy
+		require.Equal(t, "1.2.3.4 http example.com", get(ts, http.Header{
+			"X-Forwarded-For":   {"1.2.3.4"},
+			"X-Forwarded-Proto": {"https, http"},
+			"X-Forwarded-Host":  {"evil.com", "example.com"},
+		}))
+		// Values are appended by every hop
+		require.Equal(t, "1.2.3.4 http example.com", get(ts, http.Header{
+			"X-Forwarded-For":   {"9.9.9.9, 1.2.3.4, 10.0.0.1"},
+			"X-Forwarded-Proto": {"https, http, https"},
+			"X-Forwarded-Host":  {"evil.com, example.com, internal"},
+		}))
+	})
+
+	t.Run("XRealIP", func(t *testing.T) {
+		require.Equal(t, "1.2.3.4 http "+host, get(ts, http.Header{
+			"X-Real-Ip": {"1.2.3.4"},
+		}))
+	})
+
+	t.Run("Forwarded", func(t *testing.T) {
+		require.Equal(t, "2001:db8::1 https example.com", get(ts, http.Header{
+			"Forwarded":       {`for="[2001:db8::1]:80";proto=https;host=example.com, for=10.0.0.1`},
+			"X-Forwarded-For": {"1.2.3.4"},
+		}))
+	})
+
+	t.Run("AllTrusted", func(t *testing.T) {
+		require.Equal(t, "10.0.0.2 http "+host, get(ts, http.Header{
+			"X-Forwarded-For": {"10.0.0.2, 10.0.0.1"},
+		}))
+	})
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := wine.ParseCIDRs("192.168.0.0/16", "::1", " ")
+	require.NoError(t, err)
+	require.Len(t, nets, 2)
+	require.Equal(t, "::1/128", nets[1].String())
+	_, err = wine.ParseCIDRs("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = wine.ParseCIDRs("localhost")
+	require.Error(t, err)
+}
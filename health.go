@@ -0,0 +1,272 @@
+package wine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	HealthPass = "pass"
+	HealthFail = "fail"
+)
+
+// HealthCheck returns error if the checked component is unhealthy
+type HealthCheck func(ctx context.Context) error
+
+// CheckOptions configures a health check
+type CheckOptions struct {
+	// Timeout of the check, default is 1 second
+	Timeout time.Duration
+	// CacheTTL is the duration of reusing result of the last check. Zero means checking on every request
+	CacheTTL time.Duration
+	// Liveness includes the check in _sys/live, which should fail only if process needs restart, e.g. deadlock.
+	// All checks are included in _sys/health and _sys/ready
+	Liveness bool
+}
+
+func (o *CheckOptions) withDefaults() CheckOptions {
+	var opts CheckOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	return opts
+}
+
+// CheckResult is the result of a health check
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthReport is the aggregated result of health checks
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks,omitempty"`
+}
+
+// OK returns true if all checks passed
+func (r *HealthReport) OK() bool {
+	return r.Status == HealthPass
+}
+
+type healthCheck struct {
+	name    string
+	check   HealthCheck
+	options CheckOptions
+
+	mu     sync.Mutex
+	result *CheckResult
+	// running is closed once the in-flight check finishes, so that concurrent probes share its result
+	running chan struct{}
+}
+
+func (c *healthCheck) run(ctx context.Context) *CheckResult {
+	c.mu.Lock()
+	if c.result != nil && c.options.CacheTTL > 0 && time.Since(c.result.CheckedAt) < c.options.CacheTTL {
+		r := c.result
+		c.mu.Unlock()
+		return r
+	}
+	running := c.running
+	if running == nil {
+		running = make(chan struct{})
+		c.running = running
+		// The check is shared by concurrent probes, so it's bounded only by timeout instead of the first probe's ctx
+		go func(ctx context.Context) {
+			r := c.do(ctx)
+			c.mu.Lock()
+			c.result = r
+			c.running = nil
+			c.mu.Unlock()
+			close(running)
+		}(DetachContext(ctx))
+	}
+	c.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case <-running:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.result
+	case <-ctx.Done():
+		// Probe is canceled, which isn't the result of the check
+		return &CheckResult{
+			Status:    HealthFail,
+			Error:     ctx.Err().Error(),
+			Duration:  time.Since(start).String(),
+			CheckedAt: start,
+		}
+	}
+}
+
+func (c *healthCheck) do(ctx context.Context) *CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errors.New("timeout")
+		}
+	}
+	r := &CheckResult{
+		Status:    HealthPass,
+		Duration:  time.Since(start).String(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		r.Status = HealthFail
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// HealthChecks is a registry of named health checks
+type HealthChecks struct {
+	mu     sync.RWMutex
+	checks map[string]*healthCheck
+	// shuttingDown is accessed atomically
+	shuttingDown int32
+}
+
+func NewHealthChecks() *HealthChecks {
+	return &HealthChecks{
+		checks: make(map[string]*healthCheck),
+	}
+}
+
+// Register adds check with name, which replaces the existing check with the same name
+func (h *HealthChecks) Register(name string, check HealthCheck, options *CheckOptions) {
+	if name == "" || check == nil {
+		logger.Panic("Empty name or check")
+	}
+	h.mu.Lock()
+	h.checks[name] = &healthCheck{
+		name:    name,
+		check:   check,
+		options: options.withDefaults(),
+	}
+	h.mu.Unlock()
+}
+
+// Unregister removes check of name
+func (h *HealthChecks) Unregister(name string) {
+	h.mu.Lock()
+	delete(h.checks, name)
+	h.mu.Unlock()
+}
+
+// Names returns sorted names of checks
+func (h *HealthChecks) Names() []string {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	h.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Health runs all checks concurrently
+func (h *HealthChecks) Health(ctx context.Context) *HealthReport {
+	return h.run(ctx, false)
+}
+
+// Ready runs all checks, and fails once graceful shutdown begins
+func (h *HealthChecks) Ready(ctx context.Context) *HealthReport {
+	r := h.run(ctx, false)
+	if atomic.LoadInt32(&h.shuttingDown) != 0 {
+		r.Status = HealthFail
+		r.Checks["shutdown"] = &CheckResult{
+			Status:    HealthFail,
+			Error:     "shutting down",
+			Duration:  time.Duration(0).String(),
+			CheckedAt: time.Now(),
+		}
+	}
+	return r
+}
+
+// Live runs liveness checks
+func (h *HealthChecks) Live(ctx context.Context) *HealthReport {
+	return h.run(ctx, true)
+}
+
+func (h *HealthChecks) run(ctx context.Context, liveness bool) *HealthReport {
+	h.mu.RLock()
+	checks := make([]*healthCheck, 0, len(h.checks))
+	for _, c := range h.checks {
+		if !liveness || c.options.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	results := make([]*CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	r := &HealthReport{
+		Status: HealthPass,
+		Checks: make(map[string]*CheckResult, len(checks)),
+	}
+	for i, c := range checks {
+		r.Checks[c.name] = results[i]
+		if results[i].Status != HealthPass {
+			r.Status = HealthFail
+		}
+	}
+	return r
+}
+
+func (h *HealthChecks) shutdown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+func (s *Server) handleHealth(ctx context.Context, req *Request, next Invoker) Responder {
+	return healthResponse(s.Health.Health(ctx))
+}
+
+func (s *Server) handleReady(ctx context.Context, req *Request, next Invoker) Responder {
+	return healthResponse(s.Health.Ready(ctx))
+}
+
+func (s *Server) handleLive(ctx context.Context, req *Request, next Invoker) Responder {
+	return healthResponse(s.Health.Live(ctx))
+}
+
+func healthResponse(r *HealthReport) Responder {
+	status := http.StatusOK
+	if !r.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	resp := JSON(status, r).(*Response)
+	resp.Header().Set("Cache-Control", "no-store")
+	return resp
+}
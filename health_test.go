@@ -0,0 +1,138 @@
+package wine_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecks(t *testing.T) {
+	s := wine.NewServer()
+	var calls int32
+	s.Health.Register("cache", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, &wine.CheckOptions{CacheTTL: time.Minute, Liveness: true})
+	s.Health.Register("db", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, nil)
+	s.Health.Register("queue", func(ctx context.Context) error {
+		// Ignore ctx
+		time.Sleep(time.Second)
+		return nil
+	}, &wine.CheckOptions{Timeout: 50 * time.Millisecond})
+	addr := runServer(t, s)
+
+	get := func(path string) (int, *wine.HealthReport) {
+		resp, err := http.Get("http://" + addr + "/" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		r := new(wine.HealthReport)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(r))
+		return resp.StatusCode, r
+	}
+
+	t.Run("Health", func(t *testing.T) {
+		status, r := get("_sys/health")
+		require.Equal(t, http.StatusServiceUnavailable, status)
+		require.Equal(t, wine.HealthFail, r.Status)
+		require.Equal(t, wine.HealthPass, r.Checks["cache"].Status)
+		require.Equal(t, "connection refused", r.Checks["db"].Error)
+		require.Equal(t, "timeout", r.Checks["queue"].Error)
+	})
+	t.Run("Live", func(t *testing.T) {
+		status, r := get("_sys/live")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, 1, len(r.Checks))
+		require.Equal(t, wine.HealthPass, r.Checks["cache"].Status)
+		// Cached
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+	t.Run("Shutdown", func(t *testing.T) {
+		s.Health.Unregister("db")
+		s.Health.Unregister("queue")
+		status, _ := get("_sys/ready")
+		require.Equal(t, http.StatusOK, status)
+
+		s.ShutdownDelay = 300 * time.Millisecond
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Shutdown()
+		}()
+		require.Eventually(t, func() bool {
+			status, r := get("_sys/ready")
+			return status == http.StatusServiceUnavailable && r.Checks["shutdown"] != nil
+		}, time.Second, 20*time.Millisecond)
+		require.NoError(t, <-done)
+	})
+}
+
+func TestHealthCheckCoalescing(t *testing.T) {
+	h := wine.NewHealthChecks()
+	var calls int32
+	h.Register("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}, nil)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.True(t, h.Health(context.Background()).OK())
+		}()
+	}
+	wg.Wait()
+	// Concurrent probes share the in-flight check instead of running one after another
+	require.Less(t, int64(time.Since(start)), int64(600*time.Millisecond))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Result isn't cached without CacheTTL
+	require.True(t, h.Health(context.Background()).OK())
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestHealthCheckCanceledProbe(t *testing.T) {
+	h := wine.NewHealthChecks()
+	var calls int32
+	h.Register("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, &wine.CheckOptions{CacheTTL: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan *wine.HealthReport, 1)
+	go func() {
+		first <- h.Health(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, time.Millisecond)
+	second := make(chan *wine.HealthReport, 1)
+	go func() {
+		second <- h.Health(context.Background())
+	}()
+	cancel()
+	// Only the canceled probe fails, while the shared check keeps running
+	require.False(t, (<-first).OK())
+	require.True(t, (<-second).OK())
+	// Cancellation isn't cached as the result
+	require.True(t, h.Health(context.Background()).OK())
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,48 @@
+package wine
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/gopub/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP2Options configures HTTP/2 of server
+type HTTP2Options struct {
+	// H2C enables HTTP/2 over cleartext TCP in Run, with prior knowledge or Upgrade: h2c
+	H2C bool
+	// MaxConcurrentStreams is the max number of concurrent streams per connection, default is 250
+	MaxConcurrentStreams uint32
+	// MaxReadFrameSize is the max size of frames in bytes, ranging from 16KB to 16MB, default is 1MB
+	MaxReadFrameSize uint32
+	// IdleTimeout closes connections without active streams
+	IdleTimeout time.Duration
+}
+
+// newHTTPServer returns http server configured with timeouts and HTTP/2 options of s. tlsConfig is nil for cleartext server
+func (s *Server) newHTTPServer(addr string, tlsConfig *tls.Config) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+	}
+	h2s := &http2.Server{
+		MaxConcurrentStreams: s.HTTP2.MaxConcurrentStreams,
+		MaxReadFrameSize:     s.HTTP2.MaxReadFrameSize,
+		IdleTimeout:          s.HTTP2.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		log.Panicf("Configure HTTP/2: %v", err)
+	}
+	if tlsConfig == nil && s.HTTP2.H2C {
+		srv.Handler = h2c.NewHandler(s, h2s)
+	}
+	return srv
+}
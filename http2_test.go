@@ -0,0 +1,121 @@
+package wine_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/stream"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// runServer runs s at a free port, and returns the address
+func runServer(t *testing.T, s *wine.Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	go s.Run(addr)
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+	return addr
+}
+
+func TestH2C(t *testing.T) {
+	s := wine.NewServer()
+	s.HTTP2.H2C = true
+	s.HTTP2.MaxConcurrentStreams = 10
+	s.HTTP2.MaxReadFrameSize = 1 << 20
+	s.Get("proto", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, req.Request().Proto)
+	})
+	s.Bind(http.MethodPost, "echo", stream.NewDuplexByteHandler(func(ctx context.Context, r stream.ByteReadCloser, w stream.ByteWriteCloser) {
+		defer w.Close()
+		for {
+			p, err := r.Read()
+			if err != nil {
+				return
+			}
+			if err = w.Write(append([]byte("echo "), p...)); err != nil {
+				return
+			}
+		}
+	}))
+	addr := runServer(t, s)
+	defer s.Shutdown()
+
+	h2c := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	get := func(client *http.Client) string {
+		resp, err := client.Get("http://" + addr + "/proto")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	t.Run("PriorKnowledge", func(t *testing.T) {
+		require.Equal(t, "HTTP/2.0", get(h2c))
+	})
+
+	t.Run("HTTP1", func(t *testing.T) {
+		require.Equal(t, "HTTP/1.1", get(http.DefaultClient))
+	})
+
+	t.Run("Settings", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(http2.ClientPreface))
+		require.NoError(t, err)
+		framer := http2.NewFramer(conn, bufio.NewReader(conn))
+		require.NoError(t, framer.WriteSettings())
+		f, err := framer.ReadFrame()
+		require.NoError(t, err)
+		settings, ok := f.(*http2.SettingsFrame)
+		require.True(t, ok)
+		v, ok := settings.Value(http2.SettingMaxConcurrentStreams)
+		require.True(t, ok)
+		require.Equal(t, uint32(10), v)
+		v, ok = settings.Value(http2.SettingMaxFrameSize)
+		require.True(t, ok)
+		require.Equal(t, uint32(1<<20), v)
+	})
+
+	t.Run("DuplexStream", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/echo", nil)
+		require.NoError(t, err)
+		r, w, err := stream.NewDuplexByteStream(h2c, req)
+		require.NoError(t, err)
+		defer r.Close()
+		for i := 0; i < 3; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprint(i))))
+			p, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("echo %d", i), string(p))
+		}
+		require.NoError(t, w.Close())
+	})
+}
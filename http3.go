@@ -0,0 +1,62 @@
+//go:build http3
+
+package wine
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// RunHTTP3 starts server with HTTP/3 over QUIC (UDP) and HTTPS (TCP) at the same address.
+// Responses over TCP advertise HTTP/3 in Alt-Svc header
+func (s *Server) RunHTTP3(addr, certFile, keyFile string) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		logger.Fatalf("Load key pair: %v", err)
+	}
+	tlsConfig := s.TLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+	h3 := s.newHTTP3Server(addr, tlsConfig)
+	go func() {
+		logger.Infof("Running HTTP/3 at %s ...", addr)
+		err := h3.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatalf("ListenAndServe HTTP/3: %v", err)
+		}
+	}()
+
+	logger.Infof("Running at %s ...", addr)
+	srv := s.start(addr, tlsConfig, nil)
+	srv.Handler = altSvcHandler(h3, srv.Handler)
+	err = srv.ListenAndServeTLS("", "")
+	if err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			logger.Infof("Server closed")
+		} else {
+			logger.Fatalf("ListenAndServe: %v", err)
+		}
+	}
+}
+
+func (s *Server) newHTTP3Server(addr string, tlsConfig *tls.Config) *http3.Server {
+	h3 := &http3.Server{
+		Addr:        addr,
+		Handler:     s,
+		TLSConfig:   http3.ConfigureTLSConfig(tlsConfig),
+		IdleTimeout: s.HTTP2.IdleTimeout,
+	}
+	s.addAuxServer(h3)
+	return h3
+}
+
+// altSvcHandler advertises HTTP/3 of h3
+func altSvcHandler(h3 *http3.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := h3.SetQUICHeaders(w.Header()); err != nil {
+			logger.Errorf("Set QUIC headers: %v", err)
+		}
+		h.ServeHTTP(w, req)
+	})
+}
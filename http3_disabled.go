@@ -0,0 +1,8 @@
+//go:build !http3
+
+package wine
+
+// RunHTTP3 requires build tag http3
+func (s *Server) RunHTTP3(addr, certFile, keyFile string) {
+	logger.Fatalf("HTTP/3 is disabled, build with -tags http3")
+}
@@ -0,0 +1,89 @@
+//go:build http3
+
+package wine_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/stream"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http3")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	files := ca.WriteFiles(t, dir, 1, "localhost")
+
+	s := wine.NewServer()
+	s.Get("proto", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, req.Request().Proto)
+	})
+	s.Bind(http.MethodGet, "numbers", stream.NewNDJSONHandler(func(ctx context.Context, w *stream.Writer[int]) {
+		defer w.Close()
+		for i := 0; i < 3; i++ {
+			if err := w.Write(i); err != nil {
+				return
+			}
+		}
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	go s.RunHTTP3(addr, files.Cert, files.Key)
+	defer s.Shutdown()
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	baseURL := "https://localhost:" + port
+
+	tlsConfig := &tls.Config{RootCAs: ca.Pool()}
+	h3 := &http3.Transport{TLSClientConfig: tlsConfig}
+	defer h3.Close()
+	h3Client := &http.Client{Transport: h3}
+
+	t.Run("AltSvc", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}}
+		var resp *http.Response
+		require.Eventually(t, func() bool {
+			resp, err = client.Get(baseURL + "/proto")
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		resp.Body.Close()
+		require.Equal(t, 2, resp.ProtoMajor)
+		require.Equal(t, fmt.Sprintf(`h3=":%s"; ma=2592000`, port), resp.Header.Get("Alt-Svc"))
+	})
+
+	t.Run("HTTP3", func(t *testing.T) {
+		resp, err := h3Client.Get(baseURL + "/proto")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "HTTP/3.0", string(b))
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, baseURL+"/numbers", nil)
+		require.NoError(t, err)
+		r, err := stream.OpenNDJSON[int](h3Client, req)
+		require.NoError(t, err)
+		defer r.Close()
+		for i := 0; i < 3; i++ {
+			v, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, i, v)
+		}
+	})
+}
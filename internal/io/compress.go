@@ -1,12 +1,20 @@
 package io
 
 import (
+	"bufio"
 	"compress/flate"
 	"compress/gzip"
-	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gopub/wine/mime"
+	"github.com/klauspost/compress/zstd"
 )
 
 var (
@@ -15,48 +23,249 @@ var (
 	_ http.Flusher  = (*CompressResponseWriter)(nil)
 )
 
-type CompressResponseWriter struct {
-	*ResponseWriter
-	compressWriter io.Writer
-	err            error
+// Content codings
+const (
+	Brotli  = "br"
+	Zstd    = "zstd"
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// Encodings are supported content codings in order of preference
+var Encodings = []string{Brotli, Zstd, Gzip, Deflate}
+
+// DefaultCompressibleTypes is used if CompressOptions.Types is empty
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	mime.JSON,
+	mime.XML,
+	mime.XHTML,
+	"application/javascript",
+	"application/x-javascript",
+	"application/x-ndjson",
+	"application/wasm",
+	"image/svg+xml",
+	"image/x-icon",
+	"*+json",
+	"*+xml",
+}
+
+// compressedTypes are skipped even if they match allowed types
+var compressedTypes = []string{
+	mime.GIF,
+	mime.JPEG,
+	mime.PNG,
+	mime.WEBP,
+	"image/avif",
+	"image/heic",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	mime.GZIP,
+	"application/gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	// Compressing server-sent events may delay delivery through proxies
+	mime.EventStream,
+}
+
+type compressWriter interface {
+	io.WriteCloser
+	flusher
 }
 
-func NewCompressResponseWriter(w *ResponseWriter, encoding string) (*CompressResponseWriter, error) {
+// CompressOptions controls when to compress response body
+type CompressOptions struct {
+	// MinSize is the minimum body size to compress. Streaming responses are compressed once flushed
+	MinSize int
+	// Types is the allow list of content types, e.g. "text/*", "application/json", "*+json"
+	Types []string
+}
+
+// NegotiateEncoding selects the preferred encoding from Accept-Encoding header according to q-values.
+// Empty string is returned if no supported encoding is acceptable
+func NegotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qs := make(map[string]float64, 4)
+	wildcard := -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") || strings.HasPrefix(p, "Q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qs[coding] = q
+		}
+	}
+
+	type candidate struct {
+		encoding string
+		q        float64
+		rank     int
+	}
+	var l []candidate
+	for i, enc := range Encodings {
+		q, ok := qs[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			l = append(l, candidate{encoding: enc, q: q, rank: i})
+		}
+	}
+	if len(l) == 0 {
+		return ""
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].q != l[j].q {
+			return l[i].q > l[j].q
+		}
+		return l[i].rank < l[j].rank
+	})
+	return l[0].encoding
+}
+
+func newCompressWriter(w io.Writer, encoding string) (compressWriter, error) {
 	switch encoding {
-	case "gzip":
-		cw := &CompressResponseWriter{}
-		cw.ResponseWriter = w
-		cw.compressWriter = gzip.NewWriter(w)
-		w.Header().Set("Content-Encoding", encoding)
-		return cw, nil
-	case "deflate":
+	case Brotli:
+		return brotli.NewWriterLevel(w, 5), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, fmt.Errorf("new zstd writer: %w", err)
+		}
+		return zw, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Deflate:
 		fw, err := flate.NewWriter(w, flate.DefaultCompression)
 		if err != nil {
 			return nil, fmt.Errorf("new flate writer: %w", err)
 		}
-		cw := &CompressResponseWriter{}
-		cw.compressWriter = fw
-		cw.ResponseWriter = w
-		w.Header().Set("Content-Encoding", encoding)
-		return cw, nil
+		return fw, nil
 	default:
-		return nil, errors.New("unsupported encoding")
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+}
+
+// CompressResponseWriter compresses body with the negotiated encoding.
+// Body is buffered until MinSize is reached, so that the decision can be made with
+// status code, headers and size of the response
+type CompressResponseWriter struct {
+	*ResponseWriter
+	encoding       string
+	options        CompressOptions
+	compressWriter compressWriter
+	buf            []byte
+	status         int
+	decided        bool
+	err            error
+}
+
+func NewCompressResponseWriter(w *ResponseWriter, encoding string, options CompressOptions) (*CompressResponseWriter, error) {
+	switch encoding {
+	case Brotli, Zstd, Gzip, Deflate:
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+	if len(options.Types) == 0 {
+		options.Types = DefaultCompressibleTypes
+	}
+	return &CompressResponseWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		options:        options,
+	}, nil
+}
+
+func (w *CompressResponseWriter) WriteHeader(statusCode int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.status > 0 {
+		logger.Warnf("Status code already written")
+		return
+	}
+	w.status = statusCode
+	if !bodyAllowed(statusCode) {
+		w.decide(false)
 	}
 }
 
 func (w *CompressResponseWriter) Write(data []byte) (int, error) {
-	return w.compressWriter.Write(data)
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.options.MinSize {
+			return len(data), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.compressWriter != nil {
+		return w.compressWriter.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *CompressResponseWriter) Status() int {
+	if !w.decided && w.status > 0 {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+// Encoding returns the content coding applied to body, or empty string if body isn't compressed
+func (w *CompressResponseWriter) Encoding() string {
+	if w.compressWriter == nil {
+		return ""
+	}
+	return w.encoding
 }
 
 func (w *CompressResponseWriter) Flush() {
+	if !w.decided {
+		// Streaming response, compress it regardless of size
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
 	// Flush the compressed writer, then flush httpResponseWriter
-	if f, ok := w.compressWriter.(flusher); ok {
-		if err := f.Flush(); err != nil {
+	if w.compressWriter != nil {
+		if err := w.compressWriter.Flush(); err != nil {
 			logger.Errorf("Flush: %v", err)
 			w.err = err
 		}
-		w.ResponseWriter.Flush()
 	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *CompressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return w.ResponseWriter.Hijack()
 }
 
 func (w *CompressResponseWriter) Error() error {
@@ -64,8 +273,99 @@ func (w *CompressResponseWriter) Error() error {
 }
 
 func (w *CompressResponseWriter) Close() error {
-	if closer, ok := w.compressWriter.(io.Closer); ok {
-		return closer.Close()
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.compressWriter != nil {
+		return w.compressWriter.Close()
 	}
 	return nil
 }
+
+// decide determines whether to compress, then writes header and buffered body
+func (w *CompressResponseWriter) decide(force bool) error {
+	w.decided = true
+	h := w.Header()
+	if len(w.buf) > 0 && h.Get(mime.ContentType) == "" {
+		h.Set(mime.ContentType, http.DetectContentType(w.buf))
+	}
+	if w.shouldCompress(force) {
+		cw, err := newCompressWriter(w.ResponseWriter, w.encoding)
+		if err != nil {
+			logger.Errorf("Create compress writer: %v", err)
+		} else {
+			w.compressWriter = cw
+			h.Set("Content-Encoding", w.encoding)
+			// Content-Length of uncompressed content is invalid
+			h.Del("Content-Length")
+		}
+	}
+	if w.status > 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	var err error
+	if w.compressWriter != nil {
+		_, err = w.compressWriter.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+func (w *CompressResponseWriter) shouldCompress(force bool) bool {
+	if !bodyAllowed(w.status) || w.status == http.StatusPartialContent {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if !force && len(w.buf) < w.options.MinSize {
+		return false
+	}
+	ct := mime.GetContentType(h)
+	if ct == "" || matchType(ct, compressedTypes) {
+		return false
+	}
+	return matchType(ct, w.options.Types)
+}
+
+func bodyAllowed(status int) bool {
+	if status >= 100 && status <= 199 {
+		return false
+	}
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// matchType reports whether content type t matches any pattern in patterns.
+// Pattern can be exact type, "type/*", "*+suffix" or "*"
+func matchType(t string, patterns []string) bool {
+	t = strings.ToLower(t)
+	for _, p := range patterns {
+		switch {
+		case p == "*" || p == "*/*":
+			return true
+		case strings.HasSuffix(p, "/*"):
+			if strings.HasPrefix(t, p[:len(p)-1]) {
+				return true
+			}
+		case strings.HasPrefix(p, "*"):
+			if strings.HasSuffix(t, p[1:]) {
+				return true
+			}
+		case p == t:
+			return true
+		}
+	}
+	return false
+}
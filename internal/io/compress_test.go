@@ -0,0 +1,104 @@
+package io_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gopub/wine/internal/io"
+	"github.com/gopub/wine/mime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                             "",
+		"gzip":                         io.Gzip,
+		"gzip, deflate, br":            io.Brotli,
+		"gzip, deflate, br, zstd":      io.Brotli,
+		"gzip;q=1.0, br;q=0.5":         io.Gzip,
+		"br;q=0, gzip;q=0.1":           io.Gzip,
+		"*":                            io.Brotli,
+		"*;q=0.5, br;q=0":              io.Zstd,
+		"identity":                     "",
+		"compress, GZIP;Q=0.8, zstd;q": io.Zstd,
+	}
+	for ae, enc := range cases {
+		assert.Equal(t, enc, io.NegotiateEncoding(ae), ae)
+	}
+}
+
+func TestCompressResponseWriter(t *testing.T) {
+	newWriter := func(rec *httptest.ResponseRecorder) *io.CompressResponseWriter {
+		w, err := io.NewCompressResponseWriter(io.NewResponseWriter(rec), io.Gzip, io.CompressOptions{MinSize: 16})
+		require.NoError(t, err)
+		return w
+	}
+	long := strings.Repeat("hello, world. ", 10)
+
+	t.Run("Compressed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := newWriter(rec)
+		w.Header().Set(mime.ContentType, mime.JsonUTF8)
+		w.Header().Set("Content-Length", "140")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(long))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Equal(t, io.Gzip, rec.Header().Get("Content-Encoding"))
+		assert.Empty(t, rec.Header().Get("Content-Length"))
+		r, err := gzip.NewReader(rec.Body)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, long, string(b))
+	})
+
+	t.Run("BelowMinSize", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := newWriter(rec)
+		w.Header().Set(mime.ContentType, mime.PlainUTF8)
+		_, err := w.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "hello", rec.Body.String())
+	})
+
+	t.Run("CompressedMedia", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := newWriter(rec)
+		w.Header().Set(mime.ContentType, mime.PNG)
+		_, err := w.Write([]byte(long))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, long, rec.Body.String())
+	})
+
+	t.Run("NotModified", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := newWriter(rec)
+		w.WriteHeader(http.StatusNotModified)
+		require.NoError(t, w.Close())
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Empty(t, rec.Body.Bytes())
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := newWriter(rec)
+		w.Header().Set(mime.ContentType, mime.JsonUTF8)
+		_, err := w.Write([]byte("{}"))
+		require.NoError(t, err)
+		w.Flush()
+		assert.Equal(t, io.Gzip, rec.Header().Get("Content-Encoding"))
+		assert.True(t, rec.Flushed)
+		require.NoError(t, w.Close())
+	})
+}
@@ -0,0 +1,138 @@
+package io
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrBodyTooLarge        = errors.New("decompressed body is too large")
+)
+
+// DecompressRequestBody replaces req.Body with a reader which decodes content codings listed in Content-Encoding.
+// Reading more than maxSize decompressed bytes results in ErrBodyTooLarge, which guards against zip bombs
+func DecompressRequestBody(req *http.Request, maxSize int64) error {
+	var codings []string
+	for _, v := range req.Header["Content-Encoding"] {
+		for _, c := range strings.Split(v, ",") {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if c != "" && c != "identity" {
+				codings = append(codings, c)
+			}
+		}
+	}
+	req.Header.Del("Content-Encoding")
+	if len(codings) == 0 || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	body := req.Body
+	var r io.Reader = body
+	var closers []func() error
+	// Codings are listed in the order in which they were applied
+	for i := len(codings) - 1; i >= 0; i-- {
+		dr, closer, err := newDecompressReader(r, codings[i])
+		if err != nil {
+			body.Close()
+			return err
+		}
+		r = dr
+		if closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+	req.Body = &decompressReadCloser{
+		r:       r,
+		remain:  maxSize,
+		body:    body,
+		closers: closers,
+	}
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	return nil
+}
+
+func newDecompressReader(r io.Reader, encoding string) (io.Reader, func() error, error) {
+	switch encoding {
+	case Gzip, "x-gzip":
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("new gzip reader: %w", err)
+		}
+		return gr, gr.Close, nil
+	case Deflate:
+		// Deflate should be zlib format, however some clients send raw deflate data
+		br := bufio.NewReader(r)
+		if head, err := br.Peek(2); err == nil && isZlibHeader(head) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, nil, fmt.Errorf("new zlib reader: %w", err)
+			}
+			return zr, zr.Close, nil
+		}
+		fr := flate.NewReader(br)
+		return fr, fr.Close, nil
+	case Brotli:
+		return brotli.NewReader(r), nil, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("new zstd reader: %w", err)
+		}
+		return zr, func() error {
+			zr.Close()
+			return nil
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+type decompressReadCloser struct {
+	r       io.Reader
+	remain  int64
+	body    io.Closer
+	closers []func() error
+}
+
+func (r *decompressReadCloser) Read(p []byte) (int, error) {
+	if r.remain <= 0 {
+		// Probe one byte to distinguish EOF from oversize body
+		var b [1]byte
+		n, err := r.r.Read(b[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+	n, err := r.r.Read(p)
+	r.remain -= int64(n)
+	return n, err
+}
+
+func (r *decompressReadCloser) Close() error {
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if err := r.closers[i](); err != nil {
+			logger.Errorf("Close decompress reader: %v", err)
+		}
+	}
+	return r.body.Close()
+}
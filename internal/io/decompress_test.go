@@ -0,0 +1,83 @@
+package io_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gopub/wine/internal/io"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecompressRequestBody(t *testing.T) {
+	data := []byte(strings.Repeat(`{"name":"wine"}`, 10))
+	newRequest := func(body []byte, encoding string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Encoding", encoding)
+		return req
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		req := newRequest(buf.Bytes(), "gzip")
+		require.NoError(t, io.DecompressRequestBody(req, 1024))
+		assert.Empty(t, req.Header.Get("Content-Encoding"))
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.NoError(t, req.Body.Close())
+		assert.Equal(t, data, b)
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		zbuf := new(bytes.Buffer)
+		zw := zlib.NewWriter(zbuf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		fbuf := new(bytes.Buffer)
+		fw, err := flate.NewWriter(fbuf, flate.DefaultCompression)
+		require.NoError(t, err)
+		_, err = fw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, fw.Close())
+
+		for _, body := range [][]byte{zbuf.Bytes(), fbuf.Bytes()} {
+			req := newRequest(body, "deflate")
+			require.NoError(t, io.DecompressRequestBody(req, 1024))
+			b, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, data, b)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(make([]byte, 1<<20))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		req := newRequest(buf.Bytes(), "gzip")
+		require.NoError(t, io.DecompressRequestBody(req, 1024))
+		_, err = ioutil.ReadAll(req.Body)
+		require.True(t, errors.Is(err, io.ErrBodyTooLarge))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		req := newRequest(data, "compress")
+		err := io.DecompressRequestBody(req, 1024)
+		require.True(t, errors.Is(err, io.ErrUnsupportedEncoding))
+	})
+}
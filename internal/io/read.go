@@ -123,6 +123,9 @@ func ReadBody(req *http.Request, maxMemory types.ByteUnit) (types.M, []byte, err
 			return ReadValues(req.MultipartForm.Value), nil, nil
 		}
 		return params, nil, nil
+	case mime.OffsetOctetStream, mime.PacketStream:
+		// Upload chunks may be large and packets arrive continuously, leave body to be consumed as a stream
+		return params, nil, nil
 	default:
 		body, err := ioutil.ReadAll(req.Body)
 		req.Body.Close()
@@ -62,7 +62,12 @@ func (w *ResponseWriter) Flush() {
 
 func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
-		return h.Hijack()
+		conn, brw, err := h.Hijack()
+		if err == nil && w.status == 0 {
+			// Hijacked connections are usually upgraded
+			w.status = http.StatusSwitchingProtocols
+		}
+		return conn, brw, err
 	}
 	return nil, nil, errors.New("hijack not supported")
 }
@@ -0,0 +1,274 @@
+package wine
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gopub/wine/mime"
+)
+
+// Priority of requests waiting in ConcurrencyLimiter's queue
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+const numPriorities = int(PriorityHigh) + 1
+
+// LimiterOptions configures ConcurrencyLimiter
+type LimiterOptions struct {
+	// Limit is the max number of in-flight requests, default is 100. It's the initial limit in adaptive mode
+	Limit int
+	// QueueSize is the max number of requests waiting for admission, default is Limit. Negative value disables queue
+	QueueSize int
+	// QueueTimeout is the max duration of waiting in queue, default is 1 second
+	QueueTimeout time.Duration
+	// Priority classifies requests, default is PriorityNormal. Requests of higher priority are admitted first,
+	// and take the place of lower priority requests when queue is full
+	Priority func(ctx context.Context, req *Request) Priority
+	// RetryAfter is sent in header Retry-After of shed requests, default is 1 second
+	RetryAfter time.Duration
+
+	// Adaptive adjusts limit by AIMD from latency of requests: limit increases by 1 after about limit requests
+	// finished within TargetLatency, and is multiplied by Backoff once a request exceeds it
+	Adaptive bool
+	// MinLimit and MaxLimit bound adaptive limit, default are 1 and 10 times of Limit
+	MinLimit int
+	MaxLimit int
+	// TargetLatency is the expected latency of requests, default is 100 milliseconds
+	TargetLatency time.Duration
+	// Backoff ranges in (0, 1), default is 0.9
+	Backoff float64
+}
+
+func (o *LimiterOptions) withDefaults() LimiterOptions {
+	var opts LimiterOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 100
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = opts.Limit
+	} else if opts.QueueSize < 0 {
+		opts.QueueSize = 0
+	}
+	if opts.QueueTimeout <= 0 {
+		opts.QueueTimeout = time.Second
+	}
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = time.Second
+	}
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 1
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = 10 * opts.Limit
+	}
+	if opts.TargetLatency <= 0 {
+		opts.TargetLatency = 100 * time.Millisecond
+	}
+	if opts.Backoff <= 0 || opts.Backoff >= 1 {
+		opts.Backoff = 0.9
+	}
+	return opts
+}
+
+// LimiterStats is a snapshot of ConcurrencyLimiter
+type LimiterStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	// Shed is the total number of rejected requests
+	Shed int64
+}
+
+var _ Handler = (*ConcurrencyLimiter)(nil)
+
+// ConcurrencyLimiter caps in-flight requests of routes bound with it, and sheds load with 503 once queue is full.
+// Use one limiter by s.PreHandler for all requests, or one per group by router.UseHandlers
+type ConcurrencyLimiter struct {
+	options LimiterOptions
+
+	mu          sync.Mutex
+	limit       float64
+	inFlight    int
+	queues      [numPriorities]*list.List
+	queued      int
+	shed        int64
+	lastBackoff time.Time
+}
+
+type limiterWaiter struct {
+	admitted chan bool
+}
+
+func NewConcurrencyLimiter(options *LimiterOptions) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		options: options.withDefaults(),
+	}
+	l.limit = float64(l.options.Limit)
+	if l.options.Adaptive {
+		l.limit = math.Max(float64(l.options.MinLimit), math.Min(float64(l.options.MaxLimit), l.limit))
+	}
+	for i := range l.queues {
+		l.queues[i] = list.New()
+	}
+	return l
+}
+
+func (l *ConcurrencyLimiter) HandleRequest(ctx context.Context, req *Request, next Invoker) Responder {
+	p := PriorityNormal
+	if l.options.Priority != nil {
+		p = l.options.Priority(ctx, req)
+		if p < PriorityLow {
+			p = PriorityLow
+		} else if p > PriorityHigh {
+			p = PriorityHigh
+		}
+	}
+	if !l.acquire(ctx, p) {
+		return l.reject()
+	}
+	start := time.Now()
+	var resp Responder
+	defer func() {
+		// next panicked or returned nothing to respond
+		if resp == nil {
+			l.release(time.Since(start))
+		}
+	}()
+	resp = next(ctx, req)
+	if resp == nil {
+		return nil
+	}
+	// Responders like Proxy, File and event streams do their work in Respond, so the slot is held until then
+	return ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
+		defer func() {
+			l.release(time.Since(start))
+		}()
+		resp.Respond(ctx, w)
+	})
+}
+
+// Stats returns current limit, in-flight and queued requests
+func (l *ConcurrencyLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LimiterStats{
+		Limit:    int(l.limit),
+		InFlight: l.inFlight,
+		Queued:   l.queued,
+		Shed:     l.shed,
+	}
+}
+
+func (l *ConcurrencyLimiter) String() string {
+	return "wine.ConcurrencyLimiter"
+}
+
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, p Priority) bool {
+	l.mu.Lock()
+	if l.queued == 0 && l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.queued >= l.options.QueueSize && !l.evict(p) {
+		l.shed++
+		l.mu.Unlock()
+		return false
+	}
+	w := &limiterWaiter{admitted: make(chan bool, 1)}
+	e := l.queues[p].PushBack(w)
+	l.queued++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.options.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-w.admitted:
+		return ok
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case ok := <-w.admitted:
+		// Admitted or evicted meanwhile
+		return ok
+	default:
+	}
+	l.queues[p].Remove(e)
+	l.queued--
+	l.shed++
+	return false
+}
+
+// evict rejects the latest request of the lowest priority which is lower than p
+func (l *ConcurrencyLimiter) evict(p Priority) bool {
+	for i := PriorityLow; i < p; i++ {
+		if e := l.queues[i].Back(); e != nil {
+			l.queues[i].Remove(e)
+			l.queued--
+			l.shed++
+			e.Value.(*limiterWaiter).admitted <- false
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ConcurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if l.options.Adaptive {
+		l.adjust(latency)
+	}
+	for l.queued > 0 && l.inFlight < int(l.limit) {
+		for i := PriorityHigh; i >= PriorityLow; i-- {
+			if e := l.queues[i].Front(); e != nil {
+				l.queues[i].Remove(e)
+				l.queued--
+				l.inFlight++
+				e.Value.(*limiterWaiter).admitted <- true
+				break
+			}
+		}
+	}
+}
+
+func (l *ConcurrencyLimiter) adjust(latency time.Duration) {
+	if latency <= l.options.TargetLatency {
+		l.limit = math.Min(float64(l.options.MaxLimit), l.limit+1/l.limit)
+		return
+	}
+	// Requests in flight are likely slow as well, so back off at most once per target latency
+	if now := time.Now(); now.Sub(l.lastBackoff) >= l.options.TargetLatency {
+		l.lastBackoff = now
+		l.limit = math.Max(float64(l.options.MinLimit), l.limit*l.options.Backoff)
+	}
+}
+
+func (l *ConcurrencyLimiter) reject() Responder {
+	header := make(http.Header)
+	header.Set(mime.ContentType, mime.PlainUTF8)
+	header.Set("Retry-After", strconv.Itoa(int(math.Ceil(l.options.RetryAfter.Seconds()))))
+	return &Response{
+		status: http.StatusServiceUnavailable,
+		header: header,
+		value:  "Server is busy",
+	}
+}
@@ -0,0 +1,225 @@
+package wine_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Run("Shed", func(t *testing.T) {
+		l := wine.NewConcurrencyLimiter(&wine.LimiterOptions{Limit: 1, QueueSize: -1, RetryAfter: 2 * time.Second})
+		s := wine.NewServer()
+		release := make(chan struct{})
+		s.UseHandlers(l).Get("block", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			<-release
+			return wine.Text(http.StatusOK, "done")
+		})
+		addr := runServer(t, s)
+		defer s.Shutdown()
+
+		go http.Get("http://" + addr + "/block")
+		require.Eventually(t, func() bool {
+			return l.Stats().InFlight == 1
+		}, time.Second, 10*time.Millisecond)
+		resp, err := http.Get("http://" + addr + "/block")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, "2", resp.Header.Get("Retry-After"))
+		close(release)
+		require.Eventually(t, func() bool {
+			return l.Stats().InFlight == 0
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, int64(1), l.Stats().Shed)
+	})
+
+	t.Run("Respond", func(t *testing.T) {
+		l := wine.NewConcurrencyLimiter(&wine.LimiterOptions{Limit: 1, QueueSize: -1})
+		s := wine.NewServer()
+		release := make(chan struct{})
+		s.UseHandlers(l).Get("stream", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			return wine.ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
+				<-release
+				w.WriteHeader(http.StatusOK)
+			})
+		})
+		addr := runServer(t, s)
+		defer s.Shutdown()
+
+		go http.Get("http://" + addr + "/stream")
+		require.Eventually(t, func() bool {
+			return l.Stats().InFlight == 1
+		}, time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, 1, l.Stats().InFlight)
+		resp, err := http.Get("http://" + addr + "/stream")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		close(release)
+		require.Eventually(t, func() bool {
+			return l.Stats().InFlight == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		l := wine.NewConcurrencyLimiter(&wine.LimiterOptions{Limit: 1, QueueSize: -1})
+		s := wine.NewServer(wine.WithTimeout(50 * time.Millisecond))
+		r := s.UseHandlers(l)
+		r.Get("slow", sleepHandler(100*time.Millisecond))
+		r.Get("fast", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			return wine.Text(http.StatusOK, "fast")
+		})
+		addr := runServer(t, s)
+		defer s.Shutdown()
+
+		resp, err := http.Get("http://" + addr + "/slow")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		// Slot of the abandoned handler is released once it returns
+		require.Eventually(t, func() bool {
+			return l.Stats().InFlight == 0
+		}, time.Second, 10*time.Millisecond)
+		resp, err = http.Get("http://" + addr + "/fast")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int64(0), l.Stats().Shed)
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		l := wine.NewConcurrencyLimiter(&wine.LimiterOptions{
+			Limit:        1,
+			QueueSize:    2,
+			QueueTimeout: 5 * time.Second,
+			Priority: func(ctx context.Context, req *wine.Request) wine.Priority {
+				if req.Request().Header.Get("X-Priority") == "high" {
+					return wine.PriorityHigh
+				}
+				return wine.PriorityLow
+			},
+		})
+		s := wine.NewServer()
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var order []string
+		s.UseHandlers(l).Get("{name}", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			name := req.Params().String("name")
+			if name == "block" {
+				<-release
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return wine.Text(http.StatusOK, name)
+		})
+		addr := runServer(t, s)
+		defer s.Shutdown()
+
+		statuses := make(map[string]chan int)
+		get := func(name, priority string) {
+			c := make(chan int, 1)
+			statuses[name] = c
+			req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/"+name, nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Priority", priority)
+			go func() {
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					c <- 0
+					return
+				}
+				resp.Body.Close()
+				c <- resp.StatusCode
+			}()
+		}
+		wait := func(inFlight, queued int) {
+			require.Eventually(t, func() bool {
+				st := l.Stats()
+				return st.InFlight == inFlight && st.Queued == queued
+			}, time.Second, 10*time.Millisecond)
+		}
+		get("block", "low")
+		wait(1, 0)
+		get("low1", "low")
+		wait(1, 1)
+		get("low2", "low")
+		wait(1, 2)
+		// Queue is full, the latest low priority request is evicted
+		get("high", "high")
+		require.Equal(t, http.StatusServiceUnavailable, <-statuses["low2"])
+		wait(1, 2)
+
+		close(release)
+		for _, name := range []string{"block", "high", "low1"} {
+			require.Equal(t, http.StatusOK, <-statuses[name])
+		}
+		require.Equal(t, []string{"block", "high", "low1"}, order)
+	})
+
+	t.Run("QueueTimeout", func(t *testing.T) {
+		l := wine.NewConcurrencyLimiter(&wine.LimiterOptions{Limit: 1, QueueTimeout: 50 * time.Millisecond})
+		s := wine.NewServer()
+		release := make(chan struct{})
+		s.UseHandlers(l).Get("block", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			<-release
+			return wine.Text(http.StatusOK, "done")
+		})
+		addr := runServer(t, s)
+		defer s.Shutdown()
+
+		go http.Get("http://" + addr + "/block")
+		require.Eventually(t, func() bool {
+			return l.Stats().InFlight == 1
+		}, time.Second, 10*time.Millisecond)
+		resp, err := http.Get("http://" + addr + "/block")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, 0, l.Stats().Queued)
+		close(release)
+	})
+
+	t.Run("Adaptive", func(t *testing.T) {
+		l := wine.NewConcurrencyLimiter(&wine.LimiterOptions{
+			Limit:         10,
+			Adaptive:      true,
+			MinLimit:      2,
+			TargetLatency: 5 * time.Millisecond,
+			Backoff:       0.5,
+		})
+		s := wine.NewServer()
+		r := s.UseHandlers(l)
+		r.Get("slow", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			time.Sleep(20 * time.Millisecond)
+			return wine.Text(http.StatusOK, "slow")
+		})
+		r.Get("fast", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+			return wine.Text(http.StatusOK, "fast")
+		})
+		addr := runServer(t, s)
+		defer s.Shutdown()
+
+		get := func(path string) {
+			resp, err := http.Get("http://" + addr + "/" + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+		for i := 0; i < 5; i++ {
+			get("slow")
+		}
+		require.Equal(t, 2, l.Stats().Limit)
+		for i := 0; i < 10; i++ {
+			get("fast")
+		}
+		require.Greater(t, l.Stats().Limit, 2)
+	})
+}
@@ -0,0 +1,220 @@
+package wine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// envListenFDs is the number of listeners passed to child process by StartProcess, whose fds start from 3
+	envListenFDs = "WINE_LISTEN_FDS"
+	// listenFDsStart is the first fd passed by systemd or parent process
+	listenFDsStart = 3
+)
+
+// ListenUnix listens on unix domain socket at path with file mode, e.g. 0660. Stale socket file is removed
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("chmod: %w", err)
+	}
+	return l, nil
+}
+
+// SystemdListeners returns listeners passed by systemd socket activation, i.e. LISTEN_PID and LISTEN_FDS
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("parse LISTEN_FDS: %w", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return fileListeners(n, names)
+}
+
+// InheritedListeners returns listeners passed by parent process with StartProcess
+func InheritedListeners() ([]net.Listener, error) {
+	v := os.Getenv(envListenFDs)
+	if v == "" {
+		return nil, nil
+	}
+	os.Unsetenv(envListenFDs)
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", envListenFDs, err)
+	}
+	return fileListeners(n, nil)
+}
+
+func fileListeners(n int, names []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		name := fmt.Sprint("fd", fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("file listener %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+var inherited struct {
+	once      sync.Once
+	mu        sync.Mutex
+	listeners []net.Listener
+}
+
+func loadInheritedListeners() {
+	for _, load := range []func() ([]net.Listener, error){SystemdListeners, InheritedListeners} {
+		l, err := load()
+		if err != nil {
+			logger.Errorf("Load inherited listeners: %v", err)
+			continue
+		}
+		inherited.listeners = append(inherited.listeners, l...)
+	}
+}
+
+// Listen returns the listener of addr passed by systemd or parent process if it exists, otherwise listens on addr
+func Listen(network, addr string) (net.Listener, error) {
+	inherited.once.Do(loadInheritedListeners)
+	inherited.mu.Lock()
+	for i, l := range inherited.listeners {
+		if sameAddr(l.Addr(), network, addr) {
+			inherited.listeners = append(inherited.listeners[:i], inherited.listeners[i+1:]...)
+			inherited.mu.Unlock()
+			return l, nil
+		}
+	}
+	inherited.mu.Unlock()
+	return net.Listen(network, addr)
+}
+
+func sameAddr(a net.Addr, network, addr string) bool {
+	switch network {
+	case "unix":
+		return a.Network() == "unix" && a.String() == addr
+	case "tcp", "tcp4", "tcp6":
+		ta, ok := a.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || port != strconv.Itoa(ta.Port) {
+			return false
+		}
+		if host == "" {
+			return ta.IP.IsUnspecified()
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			return ip.Equal(ta.IP)
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return false
+		}
+		for _, ip := range ips {
+			if ip.Equal(ta.IP) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// StartProcess starts a process with listeners, which are reused by Listen and Run in the process
+func StartProcess(path string, args []string, listeners ...net.Listener) (*os.Process, error) {
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("cannot get file of %T", l)
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			// Socket file is used by the new process
+			ul.SetUnlinkOnClose(false)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("get file: %w", err)
+		}
+		files = append(files, f)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", envListenFDs, len(files)))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start: %w", err)
+	}
+	return cmd.Process, nil
+}
+
+// Restart starts a new process of current executable with the same arguments, which inherits listener of s,
+// then shuts down s gracefully. Server is required to be started by Run, Serve, RunTLS or RunAutoTLS.
+// RunHTTP3 isn't supported, as its UDP socket can't be inherited
+func (s *Server) Restart() (*os.Process, error) {
+	s.mu.Lock()
+	l := s.listener
+	s.mu.Unlock()
+	if l == nil {
+		return nil, errors.New("server isn't started by Run, Serve, RunTLS or RunAutoTLS")
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("get executable: %w", err)
+	}
+	p, err := StartProcess(path, os.Args[1:], l)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Started process %d", p.Pid)
+	if err = s.Shutdown(); err != nil {
+		return p, fmt.Errorf("shutdown: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,218 @@
+package wine_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	envTestChild = "WINE_TEST_CHILD"
+	envTestAddr  = "WINE_TEST_ADDR"
+	envTestCert  = "WINE_TEST_CERT"
+	envTestKey   = "WINE_TEST_KEY"
+)
+
+func newWhoamiServer(name string) *wine.Server {
+	s := wine.NewServer()
+	s.Get("whoami", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, name)
+	})
+	s.Get("slow", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		time.Sleep(200 * time.Millisecond)
+		return wine.Text(http.StatusOK, name)
+	})
+	return s
+}
+
+// init runs child process started by TestSystemdListeners, TestRestart and TestRestartTLS instead of tests
+func init() {
+	mode := os.Getenv(envTestChild)
+	if mode == "" {
+		return
+	}
+	time.AfterFunc(10*time.Second, func() {
+		os.Exit(1)
+	})
+	s := newWhoamiServer(mode)
+	switch mode {
+	case "systemd":
+		l, err := wine.SystemdListeners()
+		if err != nil || len(l) != 1 {
+			os.Exit(1)
+		}
+		s.Serve(l[0])
+	case "restart":
+		s.Run(os.Getenv(envTestAddr))
+	case "restart-tls":
+		s.RunTLS(os.Getenv(envTestAddr), os.Getenv(envTestCert), os.Getenv(envTestKey))
+	}
+	os.Exit(0)
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wine.sock")
+	// Stale socket file
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	l, err = wine.ListenUnix(path, 0660)
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+	_, err = wine.ListenUnix(path, 0660)
+	require.Error(t, err, "socket is in use")
+
+	s := newWhoamiServer("unix")
+	go s.Serve(l)
+	defer s.Shutdown()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	resp, err := client.Get("http://unix/whoami")
+	require.NoError(t, err)
+	require.Equal(t, "unix", readBody(t, resp))
+}
+
+func TestSystemdListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	// LISTEN_PID must be pid of the child, which is kept by exec
+	cmd := exec.Command("sh", "-c", `LISTEN_PID=$$ LISTEN_FDS=1 exec "$0"`, os.Args[0])
+	cmd.Env = append(os.Environ(), envTestChild+"=systemd")
+	cmd.ExtraFiles = []*os.File{f}
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+
+	// Connections are accepted by the child
+	resp, err := http.Get("http://" + l.Addr().String() + "/whoami")
+	require.NoError(t, err)
+	require.Equal(t, "systemd", readBody(t, resp))
+}
+
+func TestRestart(t *testing.T) {
+	s := newWhoamiServer("parent")
+	addr := runServer(t, s)
+	require.NoError(t, os.Setenv(envTestChild, "restart"))
+	require.NoError(t, os.Setenv(envTestAddr, addr))
+	defer os.Unsetenv(envTestChild)
+	defer os.Unsetenv(envTestAddr)
+
+	slow := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		slow <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	p, err := s.Restart()
+	require.NoError(t, err)
+	defer p.Kill()
+	// In-flight request is finished by parent
+	require.Equal(t, "parent", <-slow)
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get("http://" + addr + "/whoami")
+	require.NoError(t, err)
+	require.Equal(t, "restart", readBody(t, resp))
+}
+
+func TestRestartTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restart")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	f := ca.WriteFiles(t, dir, 1, "localhost")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	s := newWhoamiServer("parent")
+	go s.RunTLS(addr, f.Cert, f.Key)
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"},
+	}}
+	whoami := func() string {
+		resp, err := client.Get("https://" + addr + "/whoami")
+		if err != nil {
+			return err.Error()
+		}
+		return readBody(t, resp)
+	}
+	require.Eventually(t, func() bool {
+		return whoami() == "parent"
+	}, time.Second, 10*time.Millisecond)
+
+	t.Setenv(envTestChild, "restart-tls")
+	t.Setenv(envTestAddr, addr)
+	t.Setenv(envTestCert, f.Cert)
+	t.Setenv(envTestKey, f.Key)
+	p, err := s.Restart()
+	require.NoError(t, err)
+	defer p.Kill()
+	require.Equal(t, "restart-tls", whoami())
+}
+
+func TestServerTimeouts(t *testing.T) {
+	s := newWhoamiServer("timeout")
+	s.ReadHeaderTimeout = 50 * time.Millisecond
+	addr := runServer(t, s)
+	defer s.Shutdown()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /whoami HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	// Connection is closed as header isn't completed in time
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1024))
+	require.Error(t, err)
+	netErr, ok := err.(net.Error)
+	require.False(t, ok && netErr.Timeout(), "server should close connection")
+}
@@ -25,6 +25,17 @@ const (
 	PDF            = "application/pdf"
 	MSWord         = "application/msword"
 	GZIP           = "application/x-gzip"
+
+	// OffsetOctetStream is used by tus resumable upload protocol
+	OffsetOctetStream = "application/offset+octet-stream"
+	// EventStream is used by server-sent events
+	EventStream = "text/event-stream"
+	// PacketStream is the request body of duplex stream, which consists of length-prefixed packets
+	PacketStream = "application/x-packet-stream"
+	// NDJSON is newline delimited JSON, one value per line
+	NDJSON   = "application/x-ndjson"
+	MsgPack  = "application/x-msgpack"
+	Protobuf = "application/x-protobuf"
 )
 
 const (
@@ -0,0 +1,130 @@
+package wine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gopub/log"
+)
+
+// LoadCertPool loads PEM encoded CA certificates from files
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no files")
+	}
+	pool := x509.NewCertPool()
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", f)
+		}
+	}
+	return pool, nil
+}
+
+// TLSConfig returns a copy of c with client authentication of s. It's used by RunTLS and RunAutoTLS
+func (s *Server) TLSConfig(c *tls.Config) *tls.Config {
+	if c == nil {
+		c = new(tls.Config)
+	} else {
+		c = c.Clone()
+	}
+	if s.ClientCAs != nil {
+		c.ClientCAs = s.ClientCAs
+		c.ClientAuth = s.ClientAuth
+		if c.ClientAuth == tls.NoClientCert {
+			c.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	} else if s.ClientAuth != tls.NoClientCert {
+		c.ClientAuth = s.ClientAuth
+	}
+	return c
+}
+
+// PeerIdentity is the identity in verified client certificate of mutual TLS
+type PeerIdentity struct {
+	Subject  pkix.Name
+	DNSNames []string
+	Emails   []string
+	IPs      []net.IP
+	URIs     []*url.URL
+	// SPIFFEID is the URI SAN with spiffe scheme, e.g. spiffe://example.org/ns/default/sa/api
+	SPIFFEID    string
+	Certificate *x509.Certificate
+}
+
+// NewPeerIdentity returns identity of certificate
+func NewPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	id := &PeerIdentity{
+		Subject:     cert.Subject,
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		IPs:         cert.IPAddresses,
+		URIs:        cert.URIs,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			id.SPIFFEID = u.String()
+			break
+		}
+	}
+	return id
+}
+
+// Name returns SPIFFE ID, or the first DNS name, or common name of subject
+func (id *PeerIdentity) Name() string {
+	if id.SPIFFEID != "" {
+		return id.SPIFFEID
+	}
+	if len(id.DNSNames) > 0 {
+		return id.DNSNames[0]
+	}
+	return id.Subject.CommonName
+}
+
+// GetPeerIdentity returns identity of mutual TLS client, which is set by PeerAuthHandler
+func GetPeerIdentity(ctx context.Context) *PeerIdentity {
+	id, _ := ctx.Value(ckPeerIdentity).(*PeerIdentity)
+	return id
+}
+
+func withPeerIdentity(ctx context.Context, id *PeerIdentity) context.Context {
+	if id == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ckPeerIdentity, id)
+}
+
+// NewPeerAuthHandler returns an interceptor which requires verified client certificate, and saves its identity in context.
+// If toUser is not nil, its result is saved as user which can be read with GetUser, and nil user is rejected with 403
+func NewPeerAuthHandler(toUser func(ctx context.Context, id *PeerIdentity) interface{}) HandlerFunc {
+	return func(ctx context.Context, req *Request, next Invoker) Responder {
+		state := req.Request().TLS
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			return Text(http.StatusUnauthorized, "Client certificate is required")
+		}
+		id := NewPeerIdentity(state.VerifiedChains[0][0])
+		ctx = withPeerIdentity(ctx, id)
+		if toUser != nil {
+			u := toUser(ctx, id)
+			if u == nil {
+				log.FromContext(ctx).Warnf("Denied peer %s", id.Name())
+				return Status(http.StatusForbidden)
+			}
+			ctx = WithUser(ctx, u)
+		}
+		return next(ctx, req)
+	}
+}
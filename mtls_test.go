@@ -0,0 +1,135 @@
+package wine_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/api"
+	"github.com/stretchr/testify/require"
+)
+
+// writeClientCert writes client certificate with URI SANs and key into dir
+func writeClientCert(t *testing.T, ca *testCA, dir, name string, uris ...string) *api.TLSOptions {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		require.NoError(t, err)
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	o := &api.TLSOptions{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, ioutil.WriteFile(o.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, ioutil.WriteFile(o.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return o
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644))
+	serverFiles := ca.WriteFiles(t, dir, 1, "localhost")
+	serverCert, err := tls.LoadX509KeyPair(serverFiles.Cert, serverFiles.Key)
+	require.NoError(t, err)
+
+	s := wine.NewServer()
+	s.ClientCAs, err = wine.LoadCertPool(caFile)
+	require.NoError(t, err)
+	toUser := func(ctx context.Context, id *wine.PeerIdentity) interface{} {
+		if id.SPIFFEID == "" {
+			return nil
+		}
+		return path.Base(id.SPIFFEID)
+	}
+	s.Group("svc").Use(wine.NewPeerAuthHandler(toUser)).Get("whoami", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, fmt.Sprint(wine.GetPeerIdentity(ctx).Name(), " ", wine.GetUser(ctx)))
+	})
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = s.TLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}})
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(options *api.TLSOptions) (int, string, error) {
+		options.RootCAFiles = []string{caFile}
+		options.ServerName = "localhost"
+		c, err := api.NewTLSClient(options)
+		require.NoError(t, err)
+		defer c.HTTPClient().CloseIdleConnections()
+		resp, err := c.HTTPClient().Get(ts.URL + "/svc/whoami")
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b), nil
+	}
+
+	t.Run("SPIFFE", func(t *testing.T) {
+		options := writeClientCert(t, ca, dir, "api", "spiffe://example.org/ns/default/sa/api")
+		options.Pins = []string{"invalid", api.PinSHA256(ca.cert)}
+		status, body, err := get(options)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "spiffe://example.org/ns/default/sa/api api", body)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		status, _, err := get(writeClientCert(t, ca, dir, "intruder"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("NoCert", func(t *testing.T) {
+		status, _, err := get(&api.TLSOptions{})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("UntrustedCert", func(t *testing.T) {
+		_, _, err := get(writeClientCert(t, newTestCA(t), dir, "other", "spiffe://example.org/other"))
+		require.Error(t, err)
+	})
+
+	t.Run("PinMismatch", func(t *testing.T) {
+		options := writeClientCert(t, ca, dir, "api", "spiffe://example.org/ns/default/sa/api")
+		options.Pins = []string{"invalid"}
+		_, _, err := get(options)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,565 @@
+package wine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gopub/log"
+	"github.com/gopub/wine/mime"
+)
+
+var errNoUpstream = errors.New("no available upstream")
+
+// Balance is the strategy of choosing upstream for a request
+type Balance int
+
+const (
+	RoundRobin Balance = iota
+	// LeastConn chooses the upstream with the fewest active requests
+	LeastConn
+	// ConsistentHash chooses upstream by ProxyOptions.HashKey, so that requests with the same key go to the same upstream
+	ConsistentHash
+)
+
+func (b Balance) String() string {
+	switch b {
+	case RoundRobin:
+		return "round-robin"
+	case LeastConn:
+		return "least-conn"
+	case ConsistentHash:
+		return "consistent-hash"
+	default:
+		return fmt.Sprint(int(b))
+	}
+}
+
+// ProxyOptions configures Proxy
+type ProxyOptions struct {
+	Balance Balance
+	// HashKey returns the key of ConsistentHash, default is client IP resolved by server
+	HashKey func(req *http.Request) string
+	// MaxRetries is the number of retries on other upstreams after connection errors, default is 2.
+	// Only idempotent requests are retried. Negative value disables retries
+	MaxRetries int
+	// MaxFails is the number of consecutive errors after which an upstream is marked down, default is 1
+	MaxFails int
+	// FailTimeout is the duration an upstream is marked down, default is 10 seconds
+	FailTimeout time.Duration
+	// HealthCheckPath enables active health checks if it's not empty. Upstream is healthy if status is 2xx or 3xx
+	HealthCheckPath string
+	// HealthCheckInterval is the interval of active health checks, default is 10 seconds
+	HealthCheckInterval time.Duration
+	// PreserveHost sends Host of incoming request to upstream, instead of upstream's host
+	PreserveHost bool
+	// FlushInterval is the interval of flushing response. Zero or negative value means flushing immediately,
+	// which is required by streams
+	FlushInterval time.Duration
+	// Transport is used to send requests to upstreams, default is http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+func (o *ProxyOptions) withDefaults() ProxyOptions {
+	var opts ProxyOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.HashKey == nil {
+		opts.HashKey = clientIP
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 2
+	}
+	if opts.MaxFails <= 0 {
+		opts.MaxFails = 1
+	}
+	if opts.FailTimeout <= 0 {
+		opts.FailTimeout = 10 * time.Second
+	}
+	if opts.HealthCheckInterval <= 0 {
+		opts.HealthCheckInterval = 10 * time.Second
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = -1
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	return opts
+}
+
+func clientIP(req *http.Request) string {
+	if ip := GetRemoteAddr(req.Context()); ip != "" {
+		return ip
+	}
+	return remoteIP(req)
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+type upstream struct {
+	// active is accessed atomically
+	active int64
+	url    *url.URL
+
+	// fields below are guarded by Proxy.mu
+	fails     int
+	downUntil time.Time
+	unhealthy bool
+}
+
+type ringNode struct {
+	hash uint32
+	u    *upstream
+}
+
+// Proxy is a reverse proxy handler which forwards requests to upstreams.
+// WebSocket and streaming responses are passed through
+type Proxy struct {
+	options   ProxyOptions
+	upstreams []*upstream
+	ring      []ringNode
+	rp        *httputil.ReverseProxy
+	counter   uint32
+
+	mu        sync.Mutex
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewProxy creates a proxy to targets, e.g. http://10.0.0.1:8000. Active health checks are started if enabled,
+// call Close to stop them
+func NewProxy(targets []string, options *ProxyOptions) *Proxy {
+	if len(targets) == 0 {
+		log.Panic("No targets")
+	}
+	p := &Proxy{
+		options: options.withDefaults(),
+		stop:    make(chan struct{}),
+	}
+	for _, t := range targets {
+		u, err := url.Parse(t)
+		if err != nil || u.Host == "" {
+			log.Panicf("Invalid target %s", t)
+		}
+		p.upstreams = append(p.upstreams, &upstream{url: u})
+	}
+	if p.options.Balance == ConsistentHash {
+		p.buildRing()
+	}
+	p.rp = &httputil.ReverseProxy{
+		Director:      p.direct,
+		Transport:     &proxyTransport{p: p},
+		FlushInterval: p.options.FlushInterval,
+		ErrorHandler:  p.handleError,
+	}
+	if p.options.HealthCheckPath != "" {
+		go p.checkHealth()
+	}
+	return p
+}
+
+func (p *Proxy) HandleRequest(ctx context.Context, req *Request, next Invoker) Responder {
+	// Server timeout doesn't apply to upstream, e.g. streams and websocket. Request is canceled once client disconnects
+	outCtx := withClientInfo(req.Request().Context(), clientInfo{
+		IP:     GetRemoteAddr(ctx),
+		Scheme: GetScheme(ctx),
+		Host:   GetHost(ctx),
+	})
+	out := req.Request().Clone(outCtx)
+	if err := restoreBody(out, req); err != nil {
+		logger.Errorf("Restore body: %v", err)
+		return Text(http.StatusBadRequest, err.Error())
+	}
+	return ResponderFunc(func(_ context.Context, w http.ResponseWriter) {
+		p.rp.ServeHTTP(w, out)
+	})
+}
+
+// Close stops active health checks
+func (p *Proxy) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// direct rewrites headers, and upstream is chosen by proxyTransport
+func (p *Proxy) direct(req *http.Request) {
+	ctx := req.Context()
+	if ip := GetRemoteAddr(ctx); ip == "" || ip == remoteIP(req) {
+		// Values from client can't be trusted. Remote address is appended by httputil.ReverseProxy
+		req.Header.Del(HeaderXForwardedFor)
+	}
+	host, scheme := GetHost(ctx), GetScheme(ctx)
+	if host == "" {
+		host = req.Host
+	}
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+	req.Header.Set(HeaderXForwardedHost, host)
+	req.Header.Set(HeaderXForwardedProto, scheme)
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Prevent default User-Agent of http client
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+func (p *Proxy) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	logger.Errorf("Proxy %s %s: %v", req.Method, req.URL.Path, err)
+	if errors.Is(err, errNoUpstream) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+func (p *Proxy) buildRing() {
+	// Virtual nodes make keys distributed evenly
+	const replicas = 100
+	for _, u := range p.upstreams {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s#%d", u.url.Host, i)))
+			p.ring = append(p.ring, ringNode{hash: h, u: u})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+}
+
+// pick returns an available upstream which hasn't been tried
+func (p *Proxy) pick(req *http.Request, tried map[*upstream]bool) *upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	available := func(u *upstream) bool {
+		return !tried[u] && !u.unhealthy && now.After(u.downUntil)
+	}
+	switch p.options.Balance {
+	case ConsistentHash:
+		h := crc32.ChecksumIEEE([]byte(p.options.HashKey(req)))
+		i := sort.Search(len(p.ring), func(i int) bool {
+			return p.ring[i].hash >= h
+		})
+		for j := 0; j < len(p.ring); j++ {
+			if n := p.ring[(i+j)%len(p.ring)]; available(n.u) {
+				return n.u
+			}
+		}
+	case LeastConn:
+		var res *upstream
+		start := int(atomic.AddUint32(&p.counter, 1))
+		for j := range p.upstreams {
+			u := p.upstreams[(start+j)%len(p.upstreams)]
+			if available(u) && (res == nil || atomic.LoadInt64(&u.active) < atomic.LoadInt64(&res.active)) {
+				res = u
+			}
+		}
+		return res
+	default:
+		start := int(atomic.AddUint32(&p.counter, 1))
+		for j := range p.upstreams {
+			if u := p.upstreams[(start+j)%len(p.upstreams)]; available(u) {
+				return u
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) fail(u *upstream, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u.fails++
+	if u.fails >= p.options.MaxFails {
+		logger.Warnf("Upstream %s is down: %v", u.url.Host, err)
+		u.fails = 0
+		u.downUntil = time.Now().Add(p.options.FailTimeout)
+	}
+}
+
+func (p *Proxy) succeed(u *upstream) {
+	p.mu.Lock()
+	u.fails = 0
+	p.mu.Unlock()
+}
+
+func (p *Proxy) checkHealth() {
+	client := &http.Client{
+		Transport: p.options.Transport,
+		Timeout:   p.options.HealthCheckInterval,
+	}
+	ticker := time.NewTicker(p.options.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, u := range p.upstreams {
+			healthy := p.check(client, u)
+			p.mu.Lock()
+			if u.unhealthy == healthy {
+				logger.Infof("Upstream %s healthy=%t", u.url.Host, healthy)
+			}
+			u.unhealthy = !healthy
+			p.mu.Unlock()
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Proxy) check(client *http.Client, u *upstream) bool {
+	resp, err := client.Get(singleJoiningSlash(u.url.String(), p.options.HealthCheckPath))
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+type proxyTransport struct {
+	p *Proxy
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.p
+	retriable := p.options.MaxRetries > 0 && isIdempotent(req.Method) &&
+		(req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+	tried := make(map[*upstream]bool)
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		u := p.pick(req, tried)
+		if u == nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, errNoUpstream
+		}
+		tried[u] = true
+		out := req.Clone(req.Context())
+		out.URL.Scheme = u.url.Scheme
+		out.URL.Host = u.url.Host
+		out.URL.Path = singleJoiningSlash(u.url.Path, req.URL.Path)
+		if u.url.RawQuery != "" && out.URL.RawQuery != "" {
+			out.URL.RawQuery = u.url.RawQuery + "&" + out.URL.RawQuery
+		} else if u.url.RawQuery != "" {
+			out.URL.RawQuery = u.url.RawQuery
+		}
+		if !p.options.PreserveHost {
+			out.Host = ""
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("get body: %w", err)
+			}
+			out.Body = body
+		}
+		atomic.AddInt64(&u.active, 1)
+		resp, err := p.options.Transport.RoundTrip(out)
+		if err != nil {
+			atomic.AddInt64(&u.active, -1)
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			p.fail(u, err)
+			lastErr = err
+			if !retriable || attempt >= p.options.MaxRetries {
+				return nil, err
+			}
+			logger.Warnf("Retry %s %s: %v", req.Method, req.URL.Path, err)
+			continue
+		}
+		p.succeed(u)
+		release := func() {
+			atomic.AddInt64(&u.active, -1)
+		}
+		if rwc, ok := resp.Body.(io.ReadWriteCloser); ok {
+			// Upgraded connection, e.g. websocket
+			resp.Body = &upstreamConn{ReadWriteCloser: rwc, release: release}
+		} else {
+			resp.Body = &upstreamBody{ReadCloser: resp.Body, release: release}
+		}
+		return resp, nil
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// upstreamBody decreases active requests of upstream once it's closed
+type upstreamBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *upstreamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+type upstreamConn struct {
+	io.ReadWriteCloser
+	once    sync.Once
+	release func()
+}
+
+func (c *upstreamConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// restoreBody makes request body, which has been consumed by parsing, readable and replayable for upstream
+func restoreBody(out *http.Request, req *Request) error {
+	var body []byte
+	switch mime.GetContentType(out.Header) {
+	case mime.OffsetOctetStream, mime.PacketStream:
+		// Body hasn't been read
+		return nil
+	case mime.FormURLEncoded:
+		body = []byte(out.PostForm.Encode())
+	case mime.FormData:
+		if out.MultipartForm == nil {
+			return nil
+		}
+		// Files may have been spooled to disk, so the form is streamed rather than buffered for every attempt
+		mw := multipart.NewWriter(ioutil.Discard)
+		out.Header.Set(mime.ContentType, mw.FormDataContentType())
+		form, boundary := out.MultipartForm, mw.Boundary()
+		out.ContentLength = -1
+		out.Body = &multipartBody{form: form, boundary: boundary}
+		out.GetBody = func() (io.ReadCloser, error) {
+			return &multipartBody{form: form, boundary: boundary}, nil
+		}
+		return nil
+	default:
+		body = req.Body()
+	}
+	out.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		out.Body = http.NoBody
+		out.GetBody = func() (io.ReadCloser, error) {
+			return http.NoBody, nil
+		}
+		return nil
+	}
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// multipartBody encodes form once it's read
+type multipartBody struct {
+	form     *multipart.Form
+	boundary string
+	once     sync.Once
+	pr       *io.PipeReader
+}
+
+func (b *multipartBody) start() {
+	pr, pw := io.Pipe()
+	b.pr = pr
+	go func() {
+		mw := multipart.NewWriter(pw)
+		err := mw.SetBoundary(b.boundary)
+		if err == nil {
+			err = writeMultipartForm(mw, b.form)
+		}
+		pw.CloseWithError(err)
+	}()
+}
+
+func (b *multipartBody) Read(p []byte) (int, error) {
+	b.once.Do(b.start)
+	return b.pr.Read(p)
+}
+
+func (b *multipartBody) Close() error {
+	// Stop encoding if it has been started
+	b.once.Do(func() {})
+	if b.pr != nil {
+		return b.pr.Close()
+	}
+	return nil
+}
+
+func writeMultipartForm(mw *multipart.Writer, form *multipart.Form) error {
+	for k, vs := range form.Value {
+		for _, v := range vs {
+			if err := mw.WriteField(k, v); err != nil {
+				return fmt.Errorf("write field: %w", err)
+			}
+		}
+	}
+	for _, files := range form.File {
+		for _, fh := range files {
+			w, err := mw.CreatePart(fh.Header)
+			if err != nil {
+				return fmt.Errorf("create part: %w", err)
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return fmt.Errorf("open %s: %w", fh.Filename, err)
+			}
+			_, err = io.Copy(w, f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("copy %s: %w", fh.Filename, err)
+			}
+		}
+	}
+	return mw.Close()
+}
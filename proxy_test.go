@@ -0,0 +1,243 @@
+package wine_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+	"github.com/gopub/wine/stream"
+	"github.com/gopub/wine/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func newUpstream(name string) *httptest.Server {
+	s := wine.NewServer()
+	s.Get("name", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, name)
+	})
+	s.Post("echo", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		h := req.Request().Header
+		return wine.Text(http.StatusOK, fmt.Sprintf("%s %s %s %s", name, h.Get("X-Forwarded-Host"), h.Get("X-Forwarded-Proto"), req.Body()))
+	})
+	s.Put("upload", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		form := req.Request().MultipartForm
+		if form == nil || len(form.File["file"]) == 0 {
+			return wine.Status(http.StatusBadRequest)
+		}
+		f, err := form.File["file"][0].Open()
+		if err != nil {
+			return wine.Text(http.StatusInternalServerError, err.Error())
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return wine.Text(http.StatusInternalServerError, err.Error())
+		}
+		return wine.Text(http.StatusOK, fmt.Sprintf("%s %s %d", name, form.Value["title"][0], len(b)))
+	})
+	s.Bind(http.MethodGet, "stream", stream.NewTextHandler(func(ctx context.Context, w stream.TextWriteCloser) {
+		defer w.Close()
+		for i := 0; i < 3; i++ {
+			w.Write(fmt.Sprint(i))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	s.WebSocket("ws", func(ctx context.Context, conn *websocket.Conn) {
+		s, err := conn.ReadText()
+		if err == nil {
+			conn.WriteText(name + " " + s)
+		}
+	})
+	return httptest.NewServer(s)
+}
+
+func getText(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+	return string(b)
+}
+
+func TestProxy(t *testing.T) {
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	defer b.Close()
+	s := wine.NewServer()
+	p := wine.NewProxy([]string{a.URL, b.URL}, nil)
+	defer p.Close()
+	s.Bind(http.MethodGet, "name", p)
+	s.Bind(http.MethodPost, "echo", p)
+	s.Bind(http.MethodGet, "stream", p)
+	s.Bind(http.MethodGet, "ws", p)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		names := map[string]int{}
+		for i := 0; i < 4; i++ {
+			names[getText(t, ts.URL+"/name")]++
+		}
+		require.Equal(t, map[string]int{"a": 2, "b": 2}, names)
+	})
+
+	t.Run("Body", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/echo", mime.JSON, strings.NewReader(`{"k":"v"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		host := strings.TrimPrefix(ts.URL, "http://")
+		require.Contains(t, []string{"a", "b"}, string(body[:1]))
+		require.Equal(t, fmt.Sprintf(` %s http {"k":"v"}`, host), string(body[1:]))
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/stream", nil)
+		require.NoError(t, err)
+		r, err := stream.NewTextReader(http.DefaultClient, req)
+		require.NoError(t, err)
+		defer r.Close()
+		for i := 0; i < 3; i++ {
+			v, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprint(i), v)
+		}
+	})
+
+	t.Run("WebSocket", func(t *testing.T) {
+		conn, _, err := websocket.Dial(context.Background(), ts.URL+"/ws", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.WriteText("hello"))
+		v, err := conn.ReadText()
+		require.NoError(t, err)
+		require.True(t, v == "a hello" || v == "b hello", v)
+	})
+}
+
+func TestProxyFailover(t *testing.T) {
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	b.Close()
+	s := wine.NewServer()
+	p := wine.NewProxy([]string{a.URL, b.URL}, nil)
+	defer p.Close()
+	s.Bind(http.MethodGet, "name", p)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	for i := 0; i < 4; i++ {
+		require.Equal(t, "a", getText(t, ts.URL+"/name"))
+	}
+}
+
+func TestProxyHealthCheck(t *testing.T) {
+	var healthy int32
+	s := wine.NewServer()
+	s.Get("health", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return wine.Status(http.StatusServiceUnavailable)
+		}
+		return wine.Status(http.StatusOK)
+	})
+	s.Get("name", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, "a")
+	})
+	a := httptest.NewServer(s)
+	defer a.Close()
+
+	s = wine.NewServer()
+	p := wine.NewProxy([]string{a.URL}, &wine.ProxyOptions{
+		HealthCheckPath:     "health",
+		HealthCheckInterval: 20 * time.Millisecond,
+	})
+	defer p.Close()
+	s.Bind(http.MethodGet, "name", p)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Get(ts.URL + "/name")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, "a", getText(t, ts.URL+"/name"))
+}
+
+func TestProxyConsistentHash(t *testing.T) {
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	defer b.Close()
+	s := wine.NewServer()
+	p := wine.NewProxy([]string{a.URL, b.URL}, &wine.ProxyOptions{
+		Balance: wine.ConsistentHash,
+		HashKey: func(req *http.Request) string {
+			return req.URL.Query().Get("user")
+		},
+	})
+	defer p.Close()
+	s.Bind(http.MethodGet, "name", p)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	names := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		name := getText(t, fmt.Sprintf("%s/name?user=%d", ts.URL, i))
+		for j := 0; j < 2; j++ {
+			require.Equal(t, name, getText(t, fmt.Sprintf("%s/name?user=%d", ts.URL, i)))
+		}
+		names[name] = true
+	}
+	require.Len(t, names, 2)
+}
+
+func TestProxyMultipart(t *testing.T) {
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	b.Close()
+	// The file is spooled to disk as it's larger than max memory of parsing
+	s := wine.NewServer(wine.WithMaxRequestMemory(1 << 10))
+	p := wine.NewProxy([]string{a.URL, b.URL}, nil)
+	defer p.Close()
+	s.Bind(http.MethodPut, "upload", p)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	content := bytes.Repeat([]byte("wine"), 16<<10)
+	for i := 0; i < 4; i++ {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		require.NoError(t, mw.WriteField("title", "photo"))
+		w, err := mw.CreateFormFile("file", "photo.jpg")
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/upload", &buf)
+		require.NoError(t, err)
+		req.Header.Set(mime.ContentType, mw.FormDataContentType())
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		// Body is replayed to a after b fails
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		require.Equal(t, fmt.Sprintf("a photo %d", len(content)), string(body))
+	}
+}
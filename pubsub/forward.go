@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/gopub/wine/sse"
+	"github.com/gopub/wine/stream"
+	"github.com/gopub/wine/websocket"
+)
+
+// Forward sends messages of sub with send until ctx is done, sub is closed or send fails.
+// sub is closed on return, and the error of send or subscription is returned
+func Forward(ctx context.Context, sub *Subscription, send func(m *Message) error) error {
+	defer sub.Close()
+	for {
+		select {
+		case m := <-sub.C():
+			if err := send(m); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			return sub.Err()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ForwardBytes writes message data to a byte stream
+func ForwardBytes(ctx context.Context, sub *Subscription, w stream.ByteWriteCloser) error {
+	return Forward(ctx, sub, func(m *Message) error {
+		return w.Write(m.Data)
+	})
+}
+
+// ForwardText writes message data to a text stream
+func ForwardText(ctx context.Context, sub *Subscription, w stream.TextWriteCloser) error {
+	return Forward(ctx, sub, func(m *Message) error {
+		return w.Write(string(m.Data))
+	})
+}
+
+// ForwardJSON writes message data, which must be valid JSON, to a JSON stream
+func ForwardJSON(ctx context.Context, sub *Subscription, w stream.JSONWriteCloser) error {
+	return Forward(ctx, sub, func(m *Message) error {
+		return w.Write(json.RawMessage(m.Data))
+	})
+}
+
+// ForwardEvents sends messages as server-sent events named after topics
+func ForwardEvents(ctx context.Context, sub *Subscription, w *sse.Writer) error {
+	return Forward(ctx, sub, func(m *Message) error {
+		return w.Send(&sse.Event{Name: m.Topic, Data: string(m.Data)})
+	})
+}
+
+// ForwardWebSocket sends messages as text messages if data is valid UTF-8, otherwise as binary messages
+func ForwardWebSocket(ctx context.Context, sub *Subscription, conn *websocket.Conn) error {
+	return Forward(ctx, sub, func(m *Message) error {
+		if utf8.Valid(m.Data) {
+			return conn.WriteMessage(websocket.TextMessage, m.Data)
+		}
+		return conn.WriteMessage(websocket.BinaryMessage, m.Data)
+	})
+}
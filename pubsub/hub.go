@@ -0,0 +1,273 @@
+// Package pubsub provides an in-process hub which fans out messages to subscribers of topics
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrSlowConsumer = errors.New("pubsub: slow consumer")
+	ErrClosed       = errors.New("pubsub: subscription closed")
+)
+
+// Message is published to a topic
+type Message struct {
+	Topic string
+	Data  []byte
+}
+
+// Policy decides what to do if buffer of a subscriber is full
+type Policy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room for the new one
+	DropOldest Policy = iota
+	// DropNewest discards the new message
+	DropNewest
+	// Disconnect closes the subscription with ErrSlowConsumer
+	Disconnect
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop_oldest"
+	case DropNewest:
+		return "drop_newest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("Policy(%d)", int(p))
+	}
+}
+
+// Backplane broadcasts messages among hubs. LocalBackplane is used by default,
+// a multi-node implementation can be built on a message broker
+type Backplane interface {
+	// Publish broadcasts m to hubs of all nodes
+	Publish(ctx context.Context, m *Message) error
+	// Attach registers deliver which is called with every message published on any node
+	Attach(deliver func(m *Message))
+}
+
+var _ Backplane = (*LocalBackplane)(nil)
+
+// LocalBackplane delivers messages to hubs in current process
+type LocalBackplane struct {
+	mu       sync.RWMutex
+	delivers []func(m *Message)
+}
+
+func NewLocalBackplane() *LocalBackplane {
+	return new(LocalBackplane)
+}
+
+func (b *LocalBackplane) Publish(ctx context.Context, m *Message) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, deliver := range b.delivers {
+		deliver(m)
+	}
+	return nil
+}
+
+func (b *LocalBackplane) Attach(deliver func(m *Message)) {
+	b.mu.Lock()
+	b.delivers = append(b.delivers, deliver)
+	b.mu.Unlock()
+}
+
+// Hub manages subscriptions of topics
+type Hub struct {
+	mu        sync.RWMutex
+	topics    map[string]map[*Subscription]bool
+	backplane Backplane
+
+	// BufferSize is the capacity of message buffer of a new subscription, default is 64
+	BufferSize int
+	// Policy is applied to a new subscription, default is DropOldest
+	Policy Policy
+}
+
+// NewHub creates a hub which broadcasts messages through backplane. LocalBackplane is used if backplane is nil
+func NewHub(backplane Backplane) *Hub {
+	if backplane == nil {
+		backplane = NewLocalBackplane()
+	}
+	h := &Hub{
+		topics:     make(map[string]map[*Subscription]bool),
+		backplane:  backplane,
+		BufferSize: 64,
+	}
+	backplane.Attach(h.deliver)
+	return h
+}
+
+// Publish sends data to subscribers of topic
+func (h *Hub) Publish(ctx context.Context, topic string, data []byte) error {
+	if err := h.backplane.Publish(ctx, &Message{Topic: topic, Data: data}); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	return nil
+}
+
+// PublishJSON sends v encoded in JSON to subscribers of topic
+func (h *Hub) PublishJSON(ctx context.Context, topic string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return h.Publish(ctx, topic, b)
+}
+
+// Subscribe creates a subscription of topics with hub's BufferSize and Policy
+func (h *Hub) Subscribe(topics ...string) *Subscription {
+	return h.SubscribeWithPolicy(h.BufferSize, h.Policy, topics...)
+}
+
+// SubscribeWithPolicy creates a subscription of topics with specified buffer size and policy
+func (h *Hub) SubscribeWithPolicy(bufferSize int, policy Policy, topics ...string) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	s := &Subscription{
+		hub:    h,
+		topics: topics,
+		policy: policy,
+		c:      make(chan *Message, bufferSize),
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	for _, t := range topics {
+		subs := h.topics[t]
+		if subs == nil {
+			subs = make(map[*Subscription]bool)
+			h.topics[t] = subs
+		}
+		subs[s] = true
+	}
+	h.mu.Unlock()
+	return s
+}
+
+// NumSubscribers returns the number of subscriptions of topic
+func (h *Hub) NumSubscribers(topic string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.topics[topic])
+}
+
+func (h *Hub) deliver(m *Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.topics[m.Topic] {
+		s.offer(m)
+	}
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range s.topics {
+		subs := h.topics[t]
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(h.topics, t)
+		}
+	}
+}
+
+// Subscription receives messages of topics
+type Subscription struct {
+	// dropped is accessed atomically, keep it 64-bit aligned
+	dropped uint64
+	hub     *Hub
+	topics  []string
+	policy  Policy
+	c       chan *Message
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// C returns the channel of messages. Select it with Done, since C is never closed
+func (s *Subscription) C() <-chan *Message {
+	return s.c
+}
+
+// Done returns a channel which is closed after the subscription is closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason of closing
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Dropped returns the number of dropped messages
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Topics returns subscribed topics
+func (s *Subscription) Topics() []string {
+	return s.topics
+}
+
+// Close unsubscribes all topics
+func (s *Subscription) Close() {
+	s.close(ErrClosed)
+	s.hub.unsubscribe(s)
+}
+
+func (s *Subscription) close(err error) {
+	s.closeOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+func (s *Subscription) offer(m *Message) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case s.c <- m:
+		return
+	default:
+	}
+	switch s.policy {
+	case DropNewest:
+		atomic.AddUint64(&s.dropped, 1)
+	case Disconnect:
+		s.close(ErrSlowConsumer)
+		// Hub's lock is held by deliver
+		go s.hub.unsubscribe(s)
+	default:
+		// Consumer may receive concurrently, so both operations are non-blocking
+		select {
+		case <-s.c:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+		select {
+		case s.c <- m:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package pubsub_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/api"
+	"github.com/gopub/wine/pubsub"
+	"github.com/gopub/wine/sse"
+	"github.com/gopub/wine/stream"
+	"github.com/gopub/wine/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, sub *pubsub.Subscription) string {
+	select {
+	case m := <-sub.C():
+		return string(m.Data)
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+		return ""
+	}
+}
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("FanOut", func(t *testing.T) {
+		h := pubsub.NewHub(nil)
+		s1 := h.Subscribe("a")
+		s2 := h.Subscribe("a", "b")
+		defer s2.Close()
+		require.Equal(t, 2, h.NumSubscribers("a"))
+
+		require.NoError(t, h.Publish(ctx, "a", []byte("1")))
+		require.NoError(t, h.PublishJSON(ctx, "b", 2))
+		require.Equal(t, "1", receive(t, s1))
+		require.Equal(t, "1", receive(t, s2))
+		require.Equal(t, "2", receive(t, s2))
+		require.Empty(t, s1.C())
+
+		s1.Close()
+		require.Equal(t, pubsub.ErrClosed, s1.Err())
+		require.Equal(t, 1, h.NumSubscribers("a"))
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		h := pubsub.NewHub(nil)
+		s := h.SubscribeWithPolicy(2, pubsub.DropOldest, "a")
+		defer s.Close()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, h.Publish(ctx, "a", []byte(fmt.Sprint(i))))
+		}
+		require.Equal(t, uint64(3), s.Dropped())
+		require.Equal(t, "3", receive(t, s))
+		require.Equal(t, "4", receive(t, s))
+	})
+
+	t.Run("DropNewest", func(t *testing.T) {
+		h := pubsub.NewHub(nil)
+		s := h.SubscribeWithPolicy(2, pubsub.DropNewest, "a")
+		defer s.Close()
+		for i := 0; i < 5; i++ {
+			require.NoError(t, h.Publish(ctx, "a", []byte(fmt.Sprint(i))))
+		}
+		require.Equal(t, uint64(3), s.Dropped())
+		require.Equal(t, "0", receive(t, s))
+		require.Equal(t, "1", receive(t, s))
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		h := pubsub.NewHub(nil)
+		h.Policy = pubsub.Disconnect
+		h.BufferSize = 1
+		s := h.Subscribe("a")
+		require.NoError(t, h.Publish(ctx, "a", []byte("1")))
+		require.NoError(t, h.Publish(ctx, "a", []byte("2")))
+		<-s.Done()
+		require.Equal(t, pubsub.ErrSlowConsumer, s.Err())
+		err := pubsub.Forward(ctx, s, func(m *pubsub.Message) error {
+			return nil
+		})
+		require.Equal(t, pubsub.ErrSlowConsumer, err)
+	})
+
+	t.Run("Backplane", func(t *testing.T) {
+		// Hubs on different nodes share a backplane
+		b := pubsub.NewLocalBackplane()
+		h1 := pubsub.NewHub(b)
+		h2 := pubsub.NewHub(b)
+		s := h2.Subscribe("a")
+		defer s.Close()
+		require.NoError(t, h1.Publish(ctx, "a", []byte("x")))
+		require.Equal(t, "x", receive(t, s))
+	})
+}
+
+func TestForward(t *testing.T) {
+	h := pubsub.NewHub(nil)
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "text", stream.NewTextHandler(func(ctx context.Context, w stream.TextWriteCloser) {
+		defer w.Close()
+		pubsub.ForwardText(ctx, h.Subscribe("text"), w)
+	}))
+	s.Bind(http.MethodGet, "events", sse.NewHandler(func(ctx context.Context, w *sse.Writer) {
+		pubsub.ForwardEvents(ctx, h.Subscribe("event"), w)
+	}))
+	s.WebSocket("ws", func(ctx context.Context, conn *websocket.Conn) {
+		pubsub.ForwardWebSocket(ctx, h.Subscribe("ws"), conn)
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	waitSubscriber := func(topic string) {
+		for i := 0; i < 100 && h.NumSubscribers(topic) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Equal(t, 1, h.NumSubscribers(topic))
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/text", nil)
+		require.NoError(t, err)
+		r, err := stream.NewTextReader(http.DefaultClient, req)
+		require.NoError(t, err)
+		defer r.Close()
+		waitSubscriber("text")
+		require.NoError(t, h.Publish(context.Background(), "text", []byte("hello")))
+		s, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, "hello", s)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		es, err := api.NewClient(http.DefaultClient).OpenEventStream(context.Background(), ts.URL+"/events", "")
+		require.NoError(t, err)
+		defer es.Close()
+		waitSubscriber("event")
+		require.NoError(t, h.PublishJSON(context.Background(), "event", 1))
+		e, err := es.Read()
+		require.NoError(t, err)
+		require.Equal(t, "event", e.Name)
+		require.Equal(t, "1", e.Data)
+	})
+
+	t.Run("WebSocket", func(t *testing.T) {
+		url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+		conn, _, err := websocket.Dial(context.Background(), url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		waitSubscriber("ws")
+		require.NoError(t, h.Publish(context.Background(), "ws", []byte{0xff}))
+		b, err := conn.ReadBinary()
+		require.NoError(t, err)
+		require.Equal(t, []byte{0xff}, b)
+	})
+}
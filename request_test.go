@@ -0,0 +1,55 @@
+package wine_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedRequestBody(t *testing.T) {
+	s := wine.NewServer()
+	s.MaxDecompressedBodySize = 1024
+	s.Post("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, req.Params().String("name"))
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	post := func(body []byte) *http.Response {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		req, err := http.NewRequest(http.MethodPost, ts.URL, buf)
+		require.NoError(t, err)
+		req.Header.Set(mime.ContentType, mime.JSON)
+		req.Header.Set("Content-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		resp := post([]byte(`{"name":"wine"}`))
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "wine", string(body))
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		resp := post(append([]byte(`{"name":"`), make([]byte, 2048)...))
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+}
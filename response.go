@@ -1,13 +1,20 @@
 package wine
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
+	gomime "mime"
 	"net/http"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gopub/log"
 	"github.com/gopub/wine/mime"
@@ -33,6 +40,7 @@ type Response struct {
 	status int
 	header http.Header
 	value  interface{}
+	body   []byte // encoded value
 }
 
 // Respond writes header and body to response writer w
@@ -55,7 +63,13 @@ func (r *Response) getBytes() []byte {
 	if body, ok := r.value.([]byte); ok {
 		return body
 	}
+	if r.body == nil {
+		r.body = r.encodeValue()
+	}
+	return r.body
+}
 
+func (r *Response) encodeValue() []byte {
 	contentType := r.header.Get(mime.ContentType)
 
 	switch {
@@ -98,6 +112,7 @@ func (r *Response) Value() interface{} {
 
 func (r *Response) SetValue(v interface{}) {
 	r.value = v
+	r.body = nil
 }
 
 // Status returns a response only with a status code
@@ -156,45 +171,51 @@ func JSON(status int, value interface{}) Responder {
 	}
 }
 
-// StreamFile creates a application/octet-stream response
-func StreamFile(r io.Reader, name string) Responder {
+// StreamFile creates a file response which supports range and conditional requests.
+// Content-Type is inferred from name's extension or sniffed from content.
+// modTime and etag are used to evaluate If-Modified-Since, If-None-Match and If-Range, zero values mean unknown
+func StreamFile(r io.ReadSeeker, name string, modTime time.Time, etag string) Responder {
 	return ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
-		logger := log.FromContext(ctx)
-		w.Header().Set(mime.ContentType, mime.OctetStream)
 		if name != "" {
 			w.Header().Set(mime.ContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
 		}
-		const size = 1024
-		buf := make([]byte, size)
-		for {
-			n, err := r.Read(buf)
-			if n > 0 {
-				if _, wErr := w.Write(buf[:n]); wErr != nil {
-					logger.Errorf("Write: %v", wErr)
-					return
-				}
-			}
-			if err != nil {
-				logger.Errorf("Read: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+		if etag != "" {
+			w.Header().Set("ETag", quoteETag(etag))
+		}
+		if req := getHTTPRequest(ctx); req != nil {
+			http.ServeContent(w, req, name, modTime, r)
+			return
+		}
+
+		// Not served by Server, e.g. in tests, just write the whole content
+		if w.Header().Get(mime.ContentType) == "" {
+			ct := gomime.TypeByExtension(filepath.Ext(name))
+			if ct == "" {
+				ct = mime.OctetStream
 			}
+			w.Header().Set(mime.ContentType, ct)
+		}
+		if !modTime.IsZero() {
+			w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, r); err != nil {
+			log.FromContext(ctx).Errorf("Copy: %v", err)
 		}
 	})
 }
 
-// File creates a application/octet-stream response
+// File creates a file response with strong ETag which is computed from b
 func File(b []byte, name string) Responder {
-	return ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
-		w.Header().Set(mime.ContentType, mime.OctetStream)
-		if name != "" {
-			w.Header().Set(mime.ContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
-		}
-		if _, err := w.Write(b); err != nil {
-			log.FromContext(ctx).Errorf("write: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-	})
+	sum := sha1.Sum(b)
+	return StreamFile(bytes.NewReader(b), name, time.Time{}, hex.EncodeToString(sum[:]))
+}
+
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return strconv.Quote(etag)
 }
 
 // StaticFile serves static files
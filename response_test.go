@@ -1,6 +1,7 @@
 package wine_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -95,3 +96,92 @@ func TestJSON(t *testing.T) {
 		require.Empty(t, cmp.Diff(v, result))
 	})
 }
+
+func TestFile(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	s := wine.NewServer()
+	s.Get("/file", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.File(data, "data.txt")
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Full", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/file")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, mime.Plain+charsetSuffix, resp.Header.Get(mime.ContentType))
+		assert.NotEmpty(t, resp.Header.Get("ETag"))
+		require.Equal(t, data, body)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/file", nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", "bytes=10-15")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, fmt.Sprintf("bytes 10-15/%d", len(data)), resp.Header.Get("Content-Range"))
+		require.Equal(t, "abcdef", string(body))
+	})
+
+	t.Run("MultiRange", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/file", nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", "bytes=0-1,10-11")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		require.Contains(t, resp.Header.Get(mime.ContentType), "multipart/byteranges")
+	})
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/file")
+		require.NoError(t, err)
+		resp.Body.Close()
+		etag := resp.Header.Get("ETag")
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/file", nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+}
+
+func TestStreamFile(t *testing.T) {
+	data := []byte("{}")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	s := wine.NewServer()
+	s.Get("/file", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.StreamFile(bytes.NewReader(data), "data.json", modTime, "")
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/file", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-Modified-Since", modTime.UTC().Format(http.TimeFormat))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/file")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, mime.JSON, resp.Header.Get(mime.ContentType))
+	require.Equal(t, data, body)
+}
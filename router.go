@@ -32,9 +32,7 @@ func NewRouter() *Router {
 }
 
 func (r *Router) bindSysHandlers() {
-	r.Get(endpointPath, r.listEndpoints)
 	r.Get(sysDatePath, handleDate)
-	r.Any(echoPath, handleEcho)
 }
 
 func (r *Router) clone() *Router {
@@ -305,27 +303,32 @@ func (r *Router) Print() {
 	}
 }
 
-func (r *Router) listEndpoints(ctx context.Context, req *Request, next Invoker) Responder {
+// endpoints returns endpoint nodes sorted by path, and their methods
+func (r *Router) endpoints() (sortableNodeList, map[*pathpkg.Node]string) {
 	l := make(sortableNodeList, 0, 10)
-	maxLenOfPath := 0
 	nodeToMethod := make(map[*pathpkg.Node]string, 10)
 	for method, root := range r.methodToRoot {
 		for _, node := range root.ListEndpoints() {
 			l = append(l, node)
 			nodeToMethod[node] = method
-			if n := len(node.Path()); n > maxLenOfPath {
-				maxLenOfPath = n
-			}
 		}
 	}
 	for _, node := range r.anyRoot.ListEndpoints() {
 		l = append(l, node)
 		nodeToMethod[node] = "*"
+	}
+	sort.Sort(l)
+	return l, nodeToMethod
+}
+
+func (r *Router) listEndpoints(ctx context.Context, req *Request, next Invoker) Responder {
+	l, nodeToMethod := r.endpoints()
+	maxLenOfPath := 0
+	for _, node := range l {
 		if n := len(node.Path()); n > maxLenOfPath {
 			maxLenOfPath = n
 		}
 	}
-	sort.Sort(l)
 	b := new(strings.Builder)
 	for i, n := range l {
 		format := fmt.Sprintf("%%3d. %%6s /%%-%ds %%s\n", maxLenOfPath)
@@ -2,15 +2,18 @@ package wine
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/gopub/environ"
 	"github.com/gopub/log"
 	"github.com/gopub/types"
 	"github.com/gopub/wine/internal/io"
@@ -18,17 +21,19 @@ import (
 )
 
 const (
-	sysDatePath  = "_sys/date"
-	endpointPath = "_debug/endpoints"
-	echoPath     = "_debug/echo"
-	faviconPath  = "favicon.ico"
+	sysDatePath = "_sys/date"
+	healthPath  = "_sys/health"
+	readyPath   = "_sys/ready"
+	livePath    = "_sys/live"
+	faviconPath = "favicon.ico"
 )
 
 var reservedPaths = map[string]bool{
-	sysDatePath:  true,
-	endpointPath: true,
-	faviconPath:  true,
-	echoPath:     true,
+	sysDatePath: true,
+	healthPath:  true,
+	readyPath:   true,
+	livePath:    true,
+	faviconPath: true,
 }
 
 const (
@@ -40,10 +45,15 @@ const (
 type Server struct {
 	*Router
 	*templateManager
-	server      *http.Server
 	sessionTTL  time.Duration
 	sessionName string
 
+	mu       sync.Mutex
+	server   *http.Server
+	listener net.Listener
+	// auxServers run along with server, e.g. HTTP redirect server and HTTP/3 server
+	auxServers []shutdowner
+
 	maxRequestMemory   types.ByteUnit
 	Header             http.Header
 	Timeout            time.Duration
@@ -51,34 +61,109 @@ type Server struct {
 	CompressionEnabled bool
 	Recovery           bool
 
+	// ReadTimeout, ReadHeaderTimeout, WriteTimeout and IdleTimeout configure the underlying http.Server
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// CompressionMinSize is the minimum size of response body to be compressed
+	CompressionMinSize types.ByteUnit
+	// CompressibleTypes is the allow list of content types to be compressed, e.g. "text/*", "application/json"
+	// Default list is used if it's empty
+	CompressibleTypes []string
+	// MaxDecompressedBodySize limits size of request body decoded according to Content-Encoding
+	MaxDecompressedBodySize types.ByteUnit
+	// TrustedProxies are networks of proxies whose forwarding headers are trusted.
+	// Client IP, scheme and host are resolved from X-Forwarded-For, X-Real-IP, Forwarded, X-Forwarded-Proto
+	// and X-Forwarded-Host set by them, which can be read with GetRemoteAddr, GetScheme and GetHost
+	TrustedProxies []*net.IPNet
+	// HTTP2 configures HTTP/2 and h2c
+	HTTP2 HTTP2Options
+	// ClientCAs verifies client certificates of mutual TLS in RunTLS and RunAutoTLS
+	ClientCAs *x509.CertPool
+	// ClientAuth is the policy of client certificates, e.g. tls.RequireAndVerifyClientCert.
+	// Default is tls.VerifyClientCertIfGiven if ClientCAs is set
+	ClientAuth tls.ClientAuthType
+
+	// Health is the registry of checks served at _sys/health, _sys/ready and _sys/live
+	Health *HealthChecks
+	// ShutdownDelay is the duration between readiness turning failing and closing listeners in Shutdown,
+	// so that load balancers stop sending new requests
+	ShutdownDelay time.Duration
+
 	invokers struct {
 		favicon  *invokerList
 		notfound *invokerList
 		options  *invokerList
 	}
+	// routeStats is set by EnableDebug
+	routeStats    *routeStatsMap
+	clientCAFiles []string
+}
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
 }
 
-// NewServer returns a server
-func NewServer() *Server {
+// NewServer returns a server configured by env and options, e.g. NewServer(WithTimeout(time.Minute)).
+// It panics if config is invalid
+func NewServer(options ...ServerOption) *Server {
+	c := NewServerConfig()
+	c.LoadEnv()
+	for _, o := range options {
+		o(c)
+	}
+	if err := c.Validate(); err != nil {
+		log.Panicf("Invalid config: %v", err)
+	}
+
 	logger := log.GetLogger("Wine")
 	logger.SetFlags(logger.Flags() ^ log.Lfunction ^ log.Lshortfile)
 	header := make(http.Header, 1)
 	header.Set("Server", "Wine")
 
 	s := &Server{
-		sessionName:        environ.String("wine.session.name", "wsessionid"),
-		sessionTTL:         environ.Duration("wine.session.ttl", defaultSessionTTL),
-		maxRequestMemory:   types.ByteUnit(environ.SizeInBytes("wine.max_memory", int(8*types.MB))),
+		sessionName:        c.SessionName,
+		sessionTTL:         time.Duration(c.SessionTTL),
+		maxRequestMemory:   types.ByteUnit(c.MaxRequestMemory),
 		Router:             NewRouter(),
 		templateManager:    newTemplateManager(),
 		Header:             header,
-		Timeout:            environ.Duration("wine.timeout", 10*time.Second),
-		CompressionEnabled: environ.Bool("wine.compression", true),
-		Recovery:           environ.Bool("wine.recovery", true),
+		Timeout:            time.Duration(c.Timeout),
+		CompressionEnabled: c.Compression,
+		CompressionMinSize: types.ByteUnit(c.CompressionMinSize),
+		Recovery:           c.Recovery,
+		ReadTimeout:        time.Duration(c.ReadTimeout),
+		ReadHeaderTimeout:  time.Duration(c.ReadHeaderTimeout),
+		WriteTimeout:       time.Duration(c.WriteTimeout),
+		IdleTimeout:        time.Duration(c.IdleTimeout),
 	}
-	if s.sessionTTL < minSessionTTL {
-		s.sessionTTL = minSessionTTL
+	s.CompressibleTypes = c.CompressibleTypes
+	s.MaxDecompressedBodySize = types.ByteUnit(c.MaxDecompressedBodySize)
+	if len(c.TrustedProxies) > 0 {
+		nets, err := ParseCIDRs(c.TrustedProxies...)
+		if err != nil {
+			log.Panicf("Invalid trusted proxies: %v", err)
+		}
+		s.TrustedProxies = nets
+	}
+	s.HTTP2.H2C = c.H2C
+	s.HTTP2.MaxConcurrentStreams = c.HTTP2MaxConcurrentStreams
+	s.HTTP2.MaxReadFrameSize = uint32(c.HTTP2MaxReadFrameSize)
+	if len(c.ClientCAFiles) > 0 {
+		pool, err := LoadCertPool(c.ClientCAFiles...)
+		if err != nil {
+			log.Panicf("Invalid client CA files: %v", err)
+		}
+		s.ClientCAs = pool
+		s.clientCAFiles = c.ClientCAFiles
 	}
+	s.Health = NewHealthChecks()
+	s.ShutdownDelay = time.Duration(c.ShutdownDelay)
+	s.Get(healthPath, s.handleHealth)
+	s.Get(readyPath, s.handleReady)
+	s.Get(livePath, s.handleLive)
 	s.invokers.favicon = newInvokerList(toHandlerList(HandlerFunc(handleFavIcon)))
 	s.invokers.notfound = newInvokerList(toHandlerList(HandlerFunc(handleNotFound)))
 	s.invokers.options = newInvokerList(toHandlerList(HandlerFunc(s.handleOptions)))
@@ -86,33 +171,33 @@ func NewServer() *Server {
 	return s
 }
 
-// Run starts server
+// Run starts server. Listener of addr passed by systemd or parent process is reused
 func (s *Server) Run(addr string) {
-	if s.server != nil {
-		logger.Fatalf("Server is running")
+	l, err := Listen("tcp", addr)
+	if err != nil {
+		logger.Fatalf("Listen: %v", err)
 	}
+	s.Serve(l)
+}
 
-	logger.Infof("Running at %s ...", addr)
-	s.server = &http.Server{Addr: addr, Handler: s}
-	err := s.server.ListenAndServe()
+// Serve starts server with listener, e.g. unix domain socket created by ListenUnix
+func (s *Server) Serve(l net.Listener) {
+	logger.Infof("Running at %s ...", l.Addr())
+	err := s.start(l.Addr().String(), nil, l).Serve(l)
 	if err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			logger.Infof("Server closed")
 		} else {
-			logger.Fatalf("ListenAndServe: %v", err)
+			logger.Fatalf("Serve: %v", err)
 		}
 	}
 }
 
-// RunTLS starts server with tls
+// RunTLS starts server with tls. Listener of addr passed by systemd or parent process is reused
 func (s *Server) RunTLS(addr, certFile, keyFile string) {
-	if s.server != nil {
-		logger.Panic("Server is running")
-	}
-
-	logger.Infof("Running at %s ...", addr)
-	s.server = &http.Server{Addr: addr, Handler: s}
-	err := s.server.ListenAndServeTLS(certFile, keyFile)
+	l := listenTLS(addr)
+	logger.Infof("Running at %s ...", l.Addr())
+	err := s.start(addr, s.TLSConfig(nil), l).ServeTLS(l, certFile, keyFile)
 	if err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			logger.Infof("Server closed")
@@ -122,9 +207,54 @@ func (s *Server) RunTLS(addr, certFile, keyFile string) {
 	}
 }
 
-// Shutdown stops server
+// listenTLS listens on addr for https, which is ":https" if addr is empty
+func listenTLS(addr string) net.Listener {
+	if addr == "" {
+		addr = ":https"
+	}
+	l, err := Listen("tcp", addr)
+	if err != nil {
+		logger.Fatalf("Listen: %v", err)
+	}
+	return l
+}
+
+// start creates http server, which panics if server is running
+func (s *Server) start(addr string, tlsConfig *tls.Config, l net.Listener) *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		logger.Panic("Server is running")
+	}
+	s.server = s.newHTTPServer(addr, tlsConfig)
+	s.listener = l
+	return s.server
+}
+
+func (s *Server) addAuxServer(srv shutdowner) {
+	s.mu.Lock()
+	s.auxServers = append(s.auxServers, srv)
+	s.mu.Unlock()
+}
+
+// Shutdown stops server gracefully. _sys/ready fails since then, and listeners are closed after ShutdownDelay
 func (s *Server) Shutdown() error {
-	return s.server.Shutdown(context.Background())
+	s.mu.Lock()
+	srv, auxServers := s.server, s.auxServers
+	s.mu.Unlock()
+	if srv == nil {
+		return errors.New("server isn't running")
+	}
+	s.Health.shutdown()
+	if s.ShutdownDelay > 0 {
+		time.Sleep(s.ShutdownDelay)
+	}
+	for _, aux := range auxServers {
+		if err := aux.Shutdown(context.Background()); err != nil {
+			logger.Errorf("Shutdown: %v", err)
+		}
+	}
+	return srv.Shutdown(context.Background())
 }
 
 // ServeHTTP implements for http.Handler interface, which will handle each http request
@@ -139,16 +269,33 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	rw = s.wrapResponseWriter(rw, req)
 	defer s.closeWriter(rw)
-	defer s.logRequest(req, rw, time.Now())
+	client := resolveClient(req, s.TrustedProxies)
+	defer s.logRequest(req, rw, client, time.Now())
 
 	sid := s.initSession(rw, req)
-	ctx, cancel := s.setupContext(req.Context(), rw, sid)
+	ctx, cancel := s.setupContext(req.Context(), req, rw, sid)
 	defer cancel()
+	ctx = withClientInfo(ctx, client)
+
+	if err := io.DecompressRequestBody(req, int64(s.MaxDecompressedBodySize)); err != nil {
+		logger.Errorf("Decompress request body: %v", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, io.ErrUnsupportedEncoding) {
+			status = http.StatusUnsupportedMediaType
+		}
+		resp := Text(status, fmt.Sprintf("Decompress request body: %v", err))
+		resp.Respond(ctx, rw)
+		return
+	}
 
 	parsedReq, err := parseRequest(req, s.maxRequestMemory)
 	if err != nil {
 		logger.Errorf("Parse request: %v", err)
-		resp := Text(http.StatusBadRequest, fmt.Sprintf("Parse request: %v", err))
+		status := http.StatusBadRequest
+		if errors.Is(err, io.ErrBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		resp := Text(status, fmt.Sprintf("Parse request: %v", err))
 		resp.Respond(ctx, rw)
 		return
 	}
@@ -167,6 +314,17 @@ func (s *Server) serve(ctx context.Context, req *Request, rw http.ResponseWriter
 	}
 	if handlers != nil && handlers.Len() > 0 {
 		invokers = newInvokerList(handlers)
+		if s.routeStats != nil {
+			st := s.routeStats.get(handlers)
+			st.begin()
+			defer func(start time.Time) {
+				status := 0
+				if w, ok := rw.(interface{ Status() int }); ok {
+					status = w.Status()
+				}
+				st.end(status, time.Since(start))
+			}(time.Now())
+		}
 	} else {
 		if method == http.MethodOptions {
 			invokers = s.invokers.options
@@ -176,16 +334,24 @@ func (s *Server) serve(ctx context.Context, req *Request, rw http.ResponseWriter
 			invokers = s.invokers.notfound
 		}
 	}
-	var resp Responder
-	if s.PreHandler != nil && !reservedPaths[path] {
-		resp = s.PreHandler.HandleRequest(ctx, req, invokers.Invoke)
-	} else {
-		resp = invokers.Invoke(ctx, req)
+	invoke := func(ctx context.Context) Responder {
+		var resp Responder
+		if s.PreHandler != nil && !reservedPaths[path] {
+			resp = s.PreHandler.HandleRequest(ctx, req, invokers.Invoke)
+		} else {
+			resp = invokers.Invoke(ctx, req)
+		}
+		if resp == nil {
+			resp = handleNotImplemented(ctx, req, nil)
+		}
+		return resp
 	}
-	if resp == nil {
-		resp = handleNotImplemented(ctx, req, nil)
+	timeout, status := s.requestTimeout(invokers.handlers, req.Request())
+	if timeout <= 0 {
+		invoke(ctx).Respond(ctx, rw)
+		return
 	}
-	resp.Respond(ctx, rw)
+	s.invokeWithTimeout(ctx, rw, timeout, status, invoke)
 }
 
 func (s *Server) wrapResponseWriter(rw http.ResponseWriter, req *http.Request) http.ResponseWriter {
@@ -197,8 +363,15 @@ func (s *Server) wrapResponseWriter(rw http.ResponseWriter, req *http.Request) h
 		return w
 	}
 
-	enc := req.Header.Get("Accept-Encoding")
-	cw, err := io.NewCompressResponseWriter(w, enc)
+	w.Header().Add("Vary", "Accept-Encoding")
+	enc := io.NegotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if enc == "" {
+		return w
+	}
+	cw, err := io.NewCompressResponseWriter(w, enc, io.CompressOptions{
+		MinSize: int(s.CompressionMinSize),
+		Types:   s.CompressibleTypes,
+	})
 	if err != nil {
 		log.Warnf("NewCompressResponseWriter: %v", err)
 		return w
@@ -250,9 +423,11 @@ func (s *Server) initSession(rw http.ResponseWriter, req *http.Request) string {
 	return sid
 }
 
-func (s *Server) setupContext(ctx context.Context, rw http.ResponseWriter, sid string) (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+func (s *Server) setupContext(ctx context.Context, req *http.Request, rw http.ResponseWriter, sid string) (context.Context, context.CancelFunc) {
+	// Timeout is applied after routing, see requestTimeout
+	ctx, cancel := context.WithCancel(ctx)
 	ctx = withTemplate(ctx, s.templates)
+	ctx = withHTTPRequest(ctx, req)
 	ctx = withResponseWriter(ctx, rw)
 	ctx = withSessionID(ctx, sid)
 	return ctx, cancel
@@ -277,14 +452,16 @@ func (s *Server) closeWriter(w http.ResponseWriter) {
 	}
 }
 
-func (s *Server) logRequest(req *http.Request, rw http.ResponseWriter, startAt time.Time) {
+func (s *Server) logRequest(req *http.Request, rw http.ResponseWriter, client clientInfo, startAt time.Time) {
 	status := 0
-	if w, ok := rw.(*io.ResponseWriter); ok {
+	if w, ok := rw.(interface{ Status() int }); ok {
 		status = w.Status()
 	}
-	info := fmt.Sprintf("%s %s %s | %d %v",
-		req.RemoteAddr,
+	info := fmt.Sprintf("%s %s %s://%s%s | %d %v",
+		client.IP,
 		req.Method,
+		client.Scheme,
+		client.Host,
 		req.RequestURI,
 		status,
 		time.Since(startAt))
@@ -0,0 +1,152 @@
+// Package sse implements server-sent events (https://html.spec.whatwg.org/multipage/server-sent-events.html)
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderLastEventID is sent by client while reconnecting
+const HeaderLastEventID = "Last-Event-ID"
+
+// Event is a server-sent event
+type Event struct {
+	ID string
+	// Name is the event type. Client treats empty name as "message"
+	Name  string
+	Data  string
+	Retry time.Duration
+}
+
+// NewJSONEvent creates an event with v encoded in JSON as data
+func NewJSONEvent(name string, v interface{}) (*Event, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	return &Event{Name: name, Data: string(b)}, nil
+}
+
+// UnmarshalData decodes JSON data into v
+func (e *Event) UnmarshalData(v interface{}) error {
+	if err := json.Unmarshal([]byte(e.Data), v); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	return nil
+}
+
+// Encode returns e in event stream format
+func (e *Event) Encode() []byte {
+	b := new(strings.Builder)
+	if e.ID != "" {
+		b.WriteString("id: " + singleLine(e.ID) + "\n")
+	}
+	if e.Name != "" {
+		b.WriteString("event: " + singleLine(e.Name) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + fmt.Sprint(e.Retry.Milliseconds()) + "\n")
+	}
+	for _, line := range splitLines(e.Data) {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// Reader parses event stream
+type Reader struct {
+	r *bufio.Reader
+	// LastEventID is the id of last received event
+	LastEventID string
+	// Retry is the reconnection time sent by server
+	Retry time.Duration
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Read returns the next event. Comments and events without data are skipped
+func (r *Reader) Read() (*Event, error) {
+	e := new(Event)
+	var data []string
+	hasData := false
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			if !hasData {
+				e = &Event{}
+				continue
+			}
+			e.ID = r.LastEventID
+			e.Data = strings.Join(data, "\n")
+			return e, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			e.Name = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.LastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				r.Retry = time.Duration(ms) * time.Millisecond
+				e.Retry = r.Retry
+			}
+		}
+	}
+}
+
+// readLine reads a line terminated by CRLF, LF or CR
+func (r *Reader) readLine() (string, error) {
+	b := new(strings.Builder)
+	for {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '\n':
+			return b.String(), nil
+		case '\r':
+			if next, err := r.r.Peek(1); err == nil && next[0] == '\n' {
+				r.r.ReadByte()
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,177 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gopub/log"
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+)
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Writer sends events to client. It's safe for concurrent use
+type Writer struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	cancel context.CancelFunc
+	err    error
+}
+
+func newWriter(w http.ResponseWriter, cancel context.CancelFunc) *Writer {
+	return &Writer{
+		w:      w,
+		cancel: cancel,
+	}
+}
+
+// Send writes e and flushes it immediately
+func (w *Writer) Send(e *Event) error {
+	return w.write(e.Encode())
+}
+
+// SendJSON sends an event with v encoded in JSON as data
+func (w *Writer) SendJSON(name string, v interface{}) error {
+	e, err := NewJSONEvent(name, v)
+	if err != nil {
+		return err
+	}
+	return w.Send(e)
+}
+
+// Comment writes a comment line which is ignored by client
+func (w *Writer) Comment(s string) error {
+	return w.write([]byte(": " + singleLine(s) + "\n\n"))
+}
+
+func (w *Writer) write(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	_, err := w.w.Write(b)
+	if err == nil {
+		if flusher, ok := w.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if e, ok := w.w.(interface{ Error() error }); ok {
+			err = e.Error()
+		}
+	}
+	if err != nil {
+		w.err = err
+		// Client is gone
+		w.cancel()
+	}
+	return err
+}
+
+func (w *Writer) close() {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = ErrClosed
+	}
+	w.mu.Unlock()
+}
+
+var _ wine.TimeoutHandler = (*Handler)(nil)
+
+// Handler serves an event stream. serve is called after replaying missed events,
+// its ctx is canceled once client disconnects
+type Handler struct {
+	serve func(ctx context.Context, w *Writer)
+
+	// Heartbeat is the interval of sending comments which keep connection alive through proxies, default is 15 seconds.
+	// Negative value disables heartbeat
+	Heartbeat time.Duration
+	// Retry is sent to client as reconnection time if it's positive
+	Retry time.Duration
+	// Buffer replays events after Last-Event-ID to reconnecting client
+	Buffer ReplayBuffer
+}
+
+func NewHandler(serve func(ctx context.Context, w *Writer)) *Handler {
+	return &Handler{
+		serve:     serve,
+		Heartbeat: 15 * time.Second,
+	}
+}
+
+// Timeout returns zero, as event stream lasts until client disconnects
+func (h *Handler) Timeout() time.Duration {
+	return 0
+}
+
+func (h *Handler) HandleRequest(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	// Events are written in Respond, whose ctx is ignored in favor of ctx passed down by interceptors
+	return wine.ResponderFunc(func(_ context.Context, rw http.ResponseWriter) {
+		rw.Header().Set(mime.ContentType, mime.EventStream)
+		rw.Header().Set("Cache-Control", "no-cache")
+		// Disable buffering of nginx
+		rw.Header().Set("X-Accel-Buffering", "no")
+		rw.WriteHeader(http.StatusOK)
+
+		// Server timeout doesn't apply to long-lived stream
+		ctx, cancel := context.WithCancel(wine.DetachContext(ctx))
+		defer cancel()
+		w := newWriter(rw, cancel)
+		defer w.close()
+		if err := h.start(req, w); err != nil {
+			log.FromContext(ctx).Errorf("Start: %v", err)
+			return
+		}
+
+		go func() {
+			var tick <-chan time.Time
+			if h.Heartbeat > 0 {
+				ticker := time.NewTicker(h.Heartbeat)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			for {
+				select {
+				case <-tick:
+					if err := w.Comment("ping"); err != nil {
+						return
+					}
+				case <-req.Request().Context().Done():
+					cancel()
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		h.serve(ctx, w)
+	})
+}
+
+// start sends retry and missed events
+func (h *Handler) start(req *wine.Request, w *Writer) error {
+	if h.Retry > 0 {
+		if err := w.write([]byte("retry: " + fmt.Sprint(h.Retry.Milliseconds()) + "\n\n")); err != nil {
+			return err
+		}
+	}
+	lastID := req.Request().Header.Get(HeaderLastEventID)
+	if h.Buffer == nil || lastID == "" {
+		// Flush header
+		return w.Comment("")
+	}
+	events, ok := h.Buffer.Since(lastID)
+	if !ok {
+		log.Warnf("Event %s is not retained, replay %d events", lastID, len(events))
+	}
+	for _, e := range events {
+		if err := w.Send(e); err != nil {
+			return err
+		}
+	}
+	return w.Comment("")
+}
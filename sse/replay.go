@@ -0,0 +1,84 @@
+package sse
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/gopub/log"
+)
+
+// ReplayBuffer retains recent events, so that reconnecting clients can resume from Last-Event-ID
+type ReplayBuffer interface {
+	// Add retains e. e.ID is assigned if it's empty
+	Add(e *Event)
+	// Since returns retained events after the event with id.
+	// ok is false if id isn't retained, then all retained events are returned
+	Since(id string) (events []*Event, ok bool)
+}
+
+var _ ReplayBuffer = (*RingBuffer)(nil)
+
+// RingBuffer retains the latest events up to capacity in memory. Assigned ids are sequence numbers
+type RingBuffer struct {
+	mu       sync.RWMutex
+	capacity int
+	events   []*Event
+	start    int
+	seq      int64
+}
+
+func NewRingBuffer(capacity int) *RingBuffer {
+	if capacity <= 0 {
+		log.Panic("capacity must be positive")
+	}
+	return &RingBuffer{
+		capacity: capacity,
+		events:   make([]*Event, 0, capacity),
+	}
+}
+
+func (b *RingBuffer) Add(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	if e.ID == "" {
+		e.ID = strconv.FormatInt(b.seq, 10)
+	}
+	if len(b.events) < b.capacity {
+		b.events = append(b.events, e)
+		return
+	}
+	b.events[b.start] = e
+	b.start = (b.start + 1) % b.capacity
+}
+
+func (b *RingBuffer) Since(id string) ([]*Event, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := len(b.events)
+	for i := n - 1; i >= 0; i-- {
+		if b.at(i).ID == id {
+			return b.slice(i+1, n), true
+		}
+	}
+	return b.slice(0, n), false
+}
+
+// Len returns the number of retained events
+func (b *RingBuffer) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.events)
+}
+
+func (b *RingBuffer) at(i int) *Event {
+	return b.events[(b.start+i)%len(b.events)]
+}
+
+func (b *RingBuffer) slice(from, to int) []*Event {
+	l := make([]*Event, 0, to-from)
+	for i := from; i < to; i++ {
+		l = append(l, b.at(i))
+	}
+	return l
+}
@@ -0,0 +1,195 @@
+package sse_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/api"
+	"github.com/gopub/wine/mime"
+	"github.com/gopub/wine/sse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvent(t *testing.T) {
+	t.Run("Encode", func(t *testing.T) {
+		e := &sse.Event{ID: "1", Name: "update", Data: "a\nb", Retry: time.Second}
+		require.Equal(t, "id: 1\nevent: update\nretry: 1000\ndata: a\ndata: b\n\n", string(e.Encode()))
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		s := ": comment\r\nid: 1\r\nevent: update\r\ndata: a\r\ndata:b\r\n\r\n" +
+			"retry: 2000\rdata\r\r" +
+			"id: 3\n\n" +
+			"data: {\"n\":1}\n\n"
+		r := sse.NewReader(strings.NewReader(s))
+		e, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, &sse.Event{ID: "1", Name: "update", Data: "a\nb"}, e)
+
+		e, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, &sse.Event{ID: "1", Retry: 2 * time.Second}, e)
+		require.Equal(t, 2*time.Second, r.Retry)
+
+		e, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, "3", e.ID)
+		var v struct{ N int }
+		require.NoError(t, e.UnmarshalData(&v))
+		require.Equal(t, 1, v.N)
+
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var buf bytes.Buffer
+		events := []*sse.Event{
+			{ID: "a", Data: ""},
+			{ID: "b", Name: "x", Data: "line1\r\nline2\rline3"},
+		}
+		for _, e := range events {
+			buf.Write(e.Encode())
+		}
+		r := sse.NewReader(&buf)
+		e, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, events[0], e)
+		e, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, "line1\nline2\nline3", e.Data)
+	})
+}
+
+func TestRingBuffer(t *testing.T) {
+	b := sse.NewRingBuffer(3)
+	for i := 0; i < 5; i++ {
+		b.Add(&sse.Event{Data: fmt.Sprint(i)})
+	}
+	require.Equal(t, 3, b.Len())
+
+	l, ok := b.Since("3")
+	require.True(t, ok)
+	require.Len(t, l, 2)
+	require.Equal(t, "4", l[0].ID)
+	require.Equal(t, "5", l[1].ID)
+
+	l, ok = b.Since("5")
+	require.True(t, ok)
+	require.Empty(t, l)
+
+	l, ok = b.Since("1")
+	require.False(t, ok)
+	require.Len(t, l, 3)
+	require.Equal(t, "3", l[0].ID)
+}
+
+func TestHandler(t *testing.T) {
+	buf := sse.NewRingBuffer(10)
+	for i := 0; i < 3; i++ {
+		buf.Add(&sse.Event{Name: "tick", Data: fmt.Sprint(i)})
+	}
+	disconnected := make(chan struct{})
+	h := sse.NewHandler(func(ctx context.Context, w *sse.Writer) {
+		require.NoError(t, w.SendJSON("user", wine.GetUserID(ctx)))
+		<-ctx.Done()
+		close(disconnected)
+	})
+	h.Buffer = buf
+	h.Retry = 3 * time.Second
+	h.Heartbeat = 20 * time.Millisecond
+	s := wine.NewServer()
+	s.Use(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return next(wine.WithUserID(ctx, 9), req)
+	}).Bind(http.MethodGet, "events", h)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Header", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/events", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, mime.EventStream, resp.Header.Get(mime.ContentType))
+		require.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+		require.Empty(t, resp.Header.Get("Content-Encoding"))
+		b := make([]byte, 64)
+		n, err := io.ReadAtLeast(resp.Body, b, len("retry: 3000\n\n"))
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(b[:n]), "retry: 3000\n\n"))
+		cancel()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatal("disconnection isn't detected")
+		}
+		disconnected = make(chan struct{})
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		es, err := api.NewClient(http.DefaultClient).OpenEventStream(context.Background(), ts.URL+"/events", "1")
+		require.NoError(t, err)
+		defer es.Close()
+		for i := 1; i < 3; i++ {
+			e, err := es.Read()
+			require.NoError(t, err)
+			require.Equal(t, "tick", e.Name)
+			require.Equal(t, fmt.Sprint(i), e.Data)
+			require.Equal(t, fmt.Sprint(i+1), es.LastEventID)
+		}
+		e, err := es.Read()
+		require.NoError(t, err)
+		require.Equal(t, "user", e.Name)
+		var uid int64
+		require.NoError(t, e.UnmarshalData(&uid))
+		require.Equal(t, int64(9), uid)
+		require.Equal(t, 3*time.Second, es.Retry)
+	})
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	h := sse.NewHandler(func(ctx context.Context, w *sse.Writer) {
+		for i := 0; i < 6; i++ {
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
+			require.NoError(t, w.Send(&sse.Event{Name: "tick", Data: fmt.Sprint(i)}))
+		}
+	})
+	s := wine.NewServer(wine.WithTimeout(100 * time.Millisecond))
+	chainCtx := make(chan context.Context, 1)
+	s.Use(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		chainCtx <- ctx
+		return next(ctx, req)
+	}).Bind(http.MethodGet, "events", h)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	es, err := api.NewClient(http.DefaultClient).OpenEventStream(context.Background(), ts.URL+"/events", "")
+	require.NoError(t, err)
+	defer es.Close()
+	ctx := <-chainCtx
+	for i := 0; i < 6; i++ {
+		e, err := es.Read()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(i), e.Data)
+		if i == 3 {
+			// Past Server.Timeout
+			require.NoError(t, ctx.Err())
+		}
+	}
+}
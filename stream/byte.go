@@ -7,17 +7,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-
-	"github.com/gopub/types"
+	"sync"
 
 	"github.com/gopub/log"
 	"github.com/gopub/wine"
-	"github.com/gopub/wine/api"
 	"github.com/gopub/wine/mime"
 )
 
 const packetHeadLen = 4
 
+// byteHeartbeat is a packet head without payload, which is skipped by reader if server announces heartbeat
+const byteHeartbeat = 0xFFFFFFFF
+
 type ByteReadCloser interface {
 	Read() (packet []byte, err error)
 	io.Closer
@@ -33,11 +34,15 @@ type byteReadCloser struct {
 	buf   *bytes.Buffer
 	block []byte
 	err   error
+
+	// heartbeat is true if heartbeat packets should be skipped
+	heartbeat bool
 }
 
-func newByteReadCloser(body io.ReadCloser) *byteReadCloser {
+func newByteReadCloser(body io.ReadCloser, heartbeat bool) *byteReadCloser {
 	r := new(byteReadCloser)
 	r.body = body
+	r.heartbeat = heartbeat
 	r.buf = new(bytes.Buffer)
 	r.block = make([]byte, 1024)
 	return r
@@ -67,21 +72,33 @@ func (r *byteReadCloser) Close() error {
 }
 
 func (r *byteReadCloser) readPacket() []byte {
-	if r.buf.Len() < packetHeadLen {
-		return nil
-	}
-	head := r.buf.Bytes()[:packetHeadLen]
-	n := int(binary.BigEndian.Uint32(head))
-	if r.buf.Len() < n+packetHeadLen {
-		return nil
+	for {
+		if r.buf.Len() < packetHeadLen {
+			return nil
+		}
+		head := r.buf.Bytes()[:packetHeadLen]
+		h := binary.BigEndian.Uint32(head)
+		if h == byteHeartbeat && r.heartbeat {
+			r.buf.Next(packetHeadLen)
+			continue
+		}
+		n := int(h)
+		if r.buf.Len() < n+packetHeadLen {
+			return nil
+		}
+		b := r.buf.Next(n + packetHeadLen)
+		// Buffer may be overwritten by next read
+		p := make([]byte, n)
+		copy(p, b[packetHeadLen:])
+		return p
 	}
-	b := r.buf.Next(n + packetHeadLen)
-	return b[packetHeadLen:]
 }
 
 type byteWriteCloser struct {
-	w    http.ResponseWriter
-	done chan<- interface{}
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	done   chan<- interface{}
+	closed bool
 }
 
 func newByteWriteCloser(w http.ResponseWriter, done chan<- interface{}) *byteWriteCloser {
@@ -94,63 +111,106 @@ func newByteWriteCloser(w http.ResponseWriter, done chan<- interface{}) *byteWri
 func (w *byteWriteCloser) Write(p []byte) error {
 	head := make([]byte, packetHeadLen)
 	binary.BigEndian.PutUint32(head, uint32(len(p)))
-	_, err := w.w.Write(head)
-	if err != nil {
-		return fmt.Errorf("write header: %w", err)
-	}
-	if _, err := w.w.Write(p); err != nil {
-		return fmt.Errorf("write packet: %w", err)
-	}
-	if flusher, ok := w.w.(http.Flusher); ok {
-		flusher.Flush()
-	}
-	if e, ok := w.w.(interface{ Error() error }); ok {
-		if e.Error() != nil {
-			return e.Error()
-		}
-	}
-	return nil
+	return w.write(append(head, p...))
 }
 
 func (w *byteWriteCloser) Close() error {
-	close(w.done)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
 	return nil
 }
 
+func (w *byteWriteCloser) heartbeat() error {
+	head := make([]byte, packetHeadLen)
+	binary.BigEndian.PutUint32(head, byteHeartbeat)
+	return w.write(head)
+}
+
+func (w *byteWriteCloser) detach() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+}
+
+func (w *byteWriteCloser) write(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return writeFlush(w.w, b)
+}
+
 func NewByteReader(client *http.Client, req *http.Request) (ByteReadCloser, error) {
-	resp, err := client.Do(req)
+	resp, err := openStream(client, req)
 	if err != nil {
-		return nil, fmt.Errorf("do request: %w", err)
+		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		err = api.ParseResult(resp, nil, true)
-		if err != nil {
-			return nil, fmt.Errorf("parse result: %w", err)
-		}
-		return nil, types.NewError(resp.StatusCode, "unknown error")
+	r := newByteReadCloser(resp.Body, hasHeartbeat(resp))
+	if err = handshakeBytes(r); err != nil {
+		r.Close()
+		return nil, err
 	}
-	r := newByteReadCloser(resp.Body)
+	return r, nil
+}
+
+func handshakeBytes(r ByteReadCloser) error {
 	greeting, err := r.Read()
 	if err != nil {
-		r.Close()
-		return nil, fmt.Errorf("handshake: %w", err)
+		return fmt.Errorf("handshake: %w", err)
 	}
-
 	if s := string(greeting); s != Greeting {
-		r.Close()
-		return nil, fmt.Errorf("expect %s, got %s", Greeting, s)
+		return fmt.Errorf("expect %s, got %s", Greeting, s)
 	}
-	return r, nil
+	return nil
 }
 
+// NewByteHandler serves a byte stream. ctx of serve is canceled once client disconnects
 func NewByteHandler(serve func(context.Context, ByteWriteCloser)) wine.Handler {
+	return newByteHandler(mime.OctetStream, serve)
+}
+
+func newByteHandler(contentType string, serve func(context.Context, ByteWriteCloser)) wine.Handler {
 	return wine.HandlerFunc(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		logger := log.FromContext(ctx)
+		logger.Debugf("Start")
+		defer logger.Debugf("Closed")
+		w := wine.GetResponseWriter(ctx)
+		w.Header().Set(mime.ContentType, contentType)
+		announceHeartbeat(w, DefaultHeartbeat)
+		done := make(chan interface{})
+		bw := newByteWriteCloser(w, done)
+		err := bw.Write([]byte(Greeting))
+		if err != nil {
+			logger.Errorf("Handshake: %v", err)
+			return wine.Status(http.StatusOK)
+		}
+		run(ctx, req, bw, done, func(ctx context.Context) {
+			serve(ctx, bw)
+		})
+		return wine.Status(http.StatusOK)
+	})
+}
+
+// NewDuplexByteHandler serves a full-duplex byte stream over HTTP/2.
+// Client sends packets in request body, which is read with r. Use NewDuplexByteStream to connect it
+func NewDuplexByteHandler(serve func(ctx context.Context, r ByteReadCloser, w ByteWriteCloser)) wine.Handler {
+	return wine.HandlerFunc(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		if req.Request().ProtoMajor < 2 {
+			// Close connection instead of draining the endless request body
+			wine.GetResponseWriter(ctx).Header().Set("Connection", "close")
+			return wine.Text(http.StatusHTTPVersionNotSupported, "HTTP/2 is required")
+		}
 		logger := log.FromContext(ctx)
 		logger.Debugf("Start")
 		defer logger.Debugf("Closed")
 		w := wine.GetResponseWriter(ctx)
 		w.Header().Set(mime.ContentType, mime.OctetStream)
+		announceHeartbeat(w, DefaultHeartbeat)
 		done := make(chan interface{})
 		bw := newByteWriteCloser(w, done)
 		err := bw.Write([]byte(Greeting))
@@ -158,8 +218,49 @@ func NewByteHandler(serve func(context.Context, ByteWriteCloser)) wine.Handler {
 			logger.Errorf("Handshake: %v", err)
 			return wine.Status(http.StatusOK)
 		}
-		go serve(ctx, bw)
-		<-done
+		br := newByteReadCloser(req.Request().Body, false)
+		run(ctx, req, bw, done, func(ctx context.Context) {
+			serve(ctx, br, bw)
+		})
 		return wine.Status(http.StatusOK)
 	})
 }
+
+// NewDuplexByteStream connects to a stream served by NewDuplexByteHandler. client must support HTTP/2.
+// Packets written to w are sent in request body, closing w ends request body
+func NewDuplexByteStream(client *http.Client, req *http.Request) (ByteReadCloser, ByteWriteCloser, error) {
+	pr, pw := io.Pipe()
+	req.Body = pr
+	req.ContentLength = -1
+	req.Header.Set(mime.ContentType, mime.PacketStream)
+	if req.Method == "" || req.Method == http.MethodGet {
+		req.Method = http.MethodPost
+	}
+	r, err := NewByteReader(client, req)
+	if err != nil {
+		pw.Close()
+		return nil, nil, err
+	}
+	return r, &pipeByteWriter{w: pw}, nil
+}
+
+type pipeByteWriter struct {
+	mu sync.Mutex
+	w  *io.PipeWriter
+}
+
+func (w *pipeByteWriter) Write(p []byte) error {
+	b := make([]byte, packetHeadLen+len(p))
+	binary.BigEndian.PutUint32(b, uint32(len(p)))
+	copy(b[packetHeadLen:], p)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.w.Write(b); err != nil {
+		return fmt.Errorf("write packet: %w", err)
+	}
+	return nil
+}
+
+func (w *pipeByteWriter) Close() error {
+	return w.w.Close()
+}
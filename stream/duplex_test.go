@@ -0,0 +1,175 @@
+package stream_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/stream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	defer func(d time.Duration) {
+		stream.DefaultHeartbeat = d
+	}(stream.DefaultHeartbeat)
+	stream.DefaultHeartbeat = 10 * time.Millisecond
+
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewTextHandler(func(ctx context.Context, w stream.TextWriteCloser) {
+		w.Write("a")
+		time.Sleep(50 * time.Millisecond)
+		w.Write("b")
+		w.Close()
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Raw", func(t *testing.T) {
+		resp, err := http.Get(ts.URL)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.True(t, strings.Contains(string(b), "\x02\x01"))
+		require.NotEmpty(t, resp.Header.Get(stream.HeaderStreamHeartbeat))
+	})
+
+	t.Run("Reader", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		r, err := stream.NewTextReader(http.DefaultClient, req)
+		require.NoError(t, err)
+		defer r.Close()
+		var res []string
+		for {
+			s, err := r.Read()
+			if err != nil {
+				break
+			}
+			res = append(res, s)
+		}
+		require.Equal(t, []string{"a", "b"}, res)
+	})
+}
+
+func TestDefaultHeartbeat(t *testing.T) {
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewTextHandler(func(ctx context.Context, w stream.TextWriteCloser) {
+		w.Write("a")
+		time.Sleep(50 * time.Millisecond)
+		// Same as heartbeat packet, which is delivered as heartbeat is disabled
+		w.Write("\x02")
+		w.Close()
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Raw", func(t *testing.T) {
+		// Reader of earlier versions parses packets split by delimiter
+		resp, err := http.Get(ts.URL)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(b), "WINE\x01a\x01\x02\x01"))
+		require.Empty(t, resp.Header.Get(stream.HeaderStreamHeartbeat))
+	})
+
+	t.Run("Reader", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		r, err := stream.NewTextReader(http.DefaultClient, req)
+		require.NoError(t, err)
+		defer r.Close()
+		var res []string
+		for {
+			s, err := r.Read()
+			if err != nil {
+				break
+			}
+			res = append(res, s)
+		}
+		require.Equal(t, []string{"a", "\x02"}, res)
+	})
+}
+
+func TestDisconnect(t *testing.T) {
+	canceled := make(chan struct{})
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewByteHandler(func(ctx context.Context, w stream.ByteWriteCloser) {
+		<-ctx.Done()
+		require.Error(t, w.Write([]byte("late")))
+		close(canceled)
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	r, err := stream.NewByteReader(http.DefaultClient, req)
+	require.NoError(t, err)
+	r.Close()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("ctx isn't canceled")
+	}
+}
+
+func TestDuplexStream(t *testing.T) {
+	s := wine.NewServer()
+	s.Bind(http.MethodPost, "/", stream.NewDuplexByteHandler(func(ctx context.Context, r stream.ByteReadCloser, w stream.ByteWriteCloser) {
+		defer w.Close()
+		for {
+			p, err := r.Read()
+			if err != nil {
+				return
+			}
+			if err = w.Write(append([]byte("echo "), p...)); err != nil {
+				return
+			}
+		}
+	}))
+	ts := httptest.NewUnstartedServer(s)
+	ts.TLS = &tls.Config{NextProtos: []string{"h2"}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	t.Run("HTTP2", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			},
+		}
+		req, err := http.NewRequest(http.MethodPost, ts.URL, nil)
+		require.NoError(t, err)
+		r, w, err := stream.NewDuplexByteStream(client, req)
+		require.NoError(t, err)
+		defer r.Close()
+		for i := 0; i < 3; i++ {
+			require.NoError(t, w.Write([]byte(fmt.Sprint(i))))
+			p, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("echo %d", i), string(p))
+		}
+		require.NoError(t, w.Close())
+		_, err = r.Read()
+		require.Error(t, err)
+	})
+
+	t.Run("HTTP1", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, nil)
+		require.NoError(t, err)
+		_, _, err = stream.NewDuplexByteStream(ts.Client(), req)
+		require.Error(t, err)
+	})
+}
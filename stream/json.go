@@ -29,8 +29,8 @@ type jsonReadCloser struct {
 	textReadCloser
 }
 
-func newJSONReadCloser(body io.ReadCloser) *jsonReadCloser {
-	r := newTextReadCloser(body)
+func newJSONReadCloser(body io.ReadCloser, heartbeat bool) *jsonReadCloser {
+	r := newTextReadCloser(body, heartbeat)
 	return &jsonReadCloser{textReadCloser: *r}
 }
 
@@ -47,12 +47,11 @@ func (r *jsonReadCloser) Read(v interface{}) error {
 }
 
 type jsonWriteCloser struct {
-	textWriteCloser
+	*textWriteCloser
 }
 
 func newJSONWriteCloser(w http.ResponseWriter, done chan<- interface{}) *jsonWriteCloser {
-	r := newTextWriteCloser(w, done)
-	return &jsonWriteCloser{textWriteCloser: *r}
+	return &jsonWriteCloser{textWriteCloser: newTextWriteCloser(w, done)}
 }
 
 func (w *jsonWriteCloser) Write(v interface{}) error {
@@ -79,7 +78,7 @@ func NewJSONReader(client *http.Client, req *http.Request) (JSONReadCloser, erro
 		}
 		return nil, types.NewError(resp.StatusCode, "unknown error")
 	}
-	r := newJSONReadCloser(resp.Body)
+	r := newJSONReadCloser(resp.Body, hasHeartbeat(resp))
 	var greeting interface{}
 	err = r.Read(&greeting)
 	if err != nil {
@@ -93,6 +92,7 @@ func NewJSONReader(client *http.Client, req *http.Request) (JSONReadCloser, erro
 	return r, nil
 }
 
+// NewJSONHandler serves a JSON stream. ctx of serve is canceled once client disconnects
 func NewJSONHandler(serve func(context.Context, JSONWriteCloser)) wine.Handler {
 	return wine.HandlerFunc(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
 		logger := log.FromContext(ctx)
@@ -100,6 +100,7 @@ func NewJSONHandler(serve func(context.Context, JSONWriteCloser)) wine.Handler {
 		defer logger.Debugf("Closed")
 		w := wine.GetResponseWriter(ctx)
 		w.Header().Set(mime.ContentType, mime.JsonUTF8)
+		announceHeartbeat(w, DefaultHeartbeat)
 		done := make(chan interface{})
 		jw := newJSONWriteCloser(w, done)
 		err := jw.Write(Greeting)
@@ -107,8 +108,9 @@ func NewJSONHandler(serve func(context.Context, JSONWriteCloser)) wine.Handler {
 			logger.Errorf("Handshake: %v", err)
 			return wine.Status(http.StatusOK)
 		}
-		go serve(ctx, jw)
-		<-done
+		run(ctx, req, jw, done, func(ctx context.Context) {
+			serve(ctx, jw)
+		})
 		return wine.Status(http.StatusOK)
 	})
 }
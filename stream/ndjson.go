@@ -0,0 +1,140 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gopub/log"
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+)
+
+// ndjsonHeartbeat is an empty line, which is skipped by reader
+const ndjsonHeartbeat = "\n"
+
+// lineReadCloser reads newline delimited packets
+type lineReadCloser struct {
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+func newLineReadCloser(body io.ReadCloser) *lineReadCloser {
+	return &lineReadCloser{
+		body: body,
+		r:    bufio.NewReader(body),
+	}
+}
+
+func (r *lineReadCloser) Read() ([]byte, error) {
+	for {
+		p, err := r.r.ReadBytes('\n')
+		p = bytes.TrimSpace(p)
+		if len(p) > 0 {
+			// Last line may end without newline
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *lineReadCloser) Close() error {
+	return r.body.Close()
+}
+
+// lineWriteCloser writes packets which don't contain newline, e.g. compact JSON
+type lineWriteCloser struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	done   chan<- interface{}
+	closed bool
+}
+
+func newLineWriteCloser(w http.ResponseWriter, done chan<- interface{}) *lineWriteCloser {
+	return &lineWriteCloser{
+		w:    w,
+		done: done,
+	}
+}
+
+func (w *lineWriteCloser) Write(p []byte) error {
+	b := make([]byte, len(p)+1)
+	copy(b, p)
+	b[len(p)] = '\n'
+	return w.write(b)
+}
+
+func (w *lineWriteCloser) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
+	return nil
+}
+
+func (w *lineWriteCloser) heartbeat() error {
+	return w.write([]byte(ndjsonHeartbeat))
+}
+
+func (w *lineWriteCloser) detach() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+}
+
+func (w *lineWriteCloser) write(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return writeFlush(w.w, b)
+}
+
+// noContent appends nothing to response, as any trailing text breaks the last line
+var noContent = wine.ResponderFunc(func(context.Context, http.ResponseWriter) {})
+
+// NewNDJSONHandler serves a stream of T in newline delimited JSON, which can be consumed by other tools, e.g. jq.
+// There is no greeting, and heartbeats are empty lines. Use OpenNDJSON to read it
+func NewNDJSONHandler[T any](serve func(context.Context, *Writer[T])) wine.Handler {
+	return wine.HandlerFunc(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		logger := log.FromContext(ctx)
+		logger.Debugf("Start")
+		defer logger.Debugf("Closed")
+		w := wine.GetResponseWriter(ctx)
+		w.Header().Set(mime.ContentType, mime.NDJSON)
+		announceHeartbeat(w, DefaultHeartbeat)
+		// Flush header as there is no greeting
+		if err := writeFlush(w, nil); err != nil {
+			logger.Errorf("Write header: %v", err)
+			return noContent
+		}
+		done := make(chan interface{})
+		lw := newLineWriteCloser(w, done)
+		run(ctx, req, lw, done, func(ctx context.Context) {
+			serve(ctx, NewWriter[T](lw, JSONCodec))
+		})
+		return noContent
+	})
+}
+
+// OpenNDJSON reads a newline delimited JSON stream, empty lines are skipped
+func OpenNDJSON[T any](client *http.Client, req *http.Request) (*Reader[T], error) {
+	resp, err := openStream(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if t := mime.GetContentType(resp.Header); t != mime.NDJSON {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expect %s, got %s", mime.NDJSON, t)
+	}
+	return NewReader[T](newLineReadCloser(resp.Body), JSONCodec), nil
+}
@@ -0,0 +1,346 @@
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopub/log"
+	"github.com/gopub/types"
+	"github.com/gopub/wine/api"
+)
+
+// ReconnectOptions configures reconnecting readers
+type ReconnectOptions struct {
+	// MinBackoff is the delay before the first retry, default is 100 milliseconds
+	MinBackoff time.Duration
+	// MaxBackoff limits the exponential delay, default is 10 seconds
+	MaxBackoff time.Duration
+	// MaxAttempts is the maximum number of consecutive failed attempts, 0 means unlimited
+	MaxAttempts int
+}
+
+func (o *ReconnectOptions) withDefaults() ReconnectOptions {
+	var opts ReconnectOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 10 * time.Second
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	return opts
+}
+
+// backoff returns a jittered delay in [d/2, d], where d grows exponentially with attempt
+func (o *ReconnectOptions) backoff(attempt int) time.Duration {
+	d := o.MaxBackoff
+	if attempt < 32 {
+		if v := o.MinBackoff << uint(attempt); v > 0 && v < d {
+			d = v
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// NewReconnectingByteReader reads a stream served by NewResumableByteHandler.
+// It reconnects with jittered exponential backoff and resumes after the last received packet.
+// req must be replayable, e.g. a GET request. Read returns io.EOF once the stream is closed by server.
+// Only the client who started the stream can resume it, cookies of the session are resent if client has no cookie jar
+func NewReconnectingByteReader(client *http.Client, req *http.Request, options *ReconnectOptions) (ByteReadCloser, error) {
+	r := newReconnector(client, req, options, func(resp *http.Response) seqReader {
+		return &byteSeqReader{r: newByteReadCloser(resp.Body, hasHeartbeat(resp))}
+	})
+	if err := r.connectWithRetry(); err != nil {
+		return nil, err
+	}
+	return &reconnectingByteReader{r: r}, nil
+}
+
+// NewReconnectingTextReader reads a stream served by NewResumableTextHandler
+func NewReconnectingTextReader(client *http.Client, req *http.Request, options *ReconnectOptions) (TextReadCloser, error) {
+	r := newReconnector(client, req, options, func(resp *http.Response) seqReader {
+		return &textSeqReader{r: newTextReadCloser(resp.Body, hasHeartbeat(resp))}
+	})
+	if err := r.connectWithRetry(); err != nil {
+		return nil, err
+	}
+	return &reconnectingTextReader{r: r}, nil
+}
+
+// NewReconnectingJSONReader reads a stream served by NewResumableJSONHandler
+func NewReconnectingJSONReader(client *http.Client, req *http.Request, options *ReconnectOptions) (JSONReadCloser, error) {
+	r := newReconnector(client, req, options, func(resp *http.Response) seqReader {
+		return &textSeqReader{r: newTextReadCloser(resp.Body, hasHeartbeat(resp))}
+	})
+	if err := r.connectWithRetry(); err != nil {
+		return nil, err
+	}
+	return &reconnectingJSONReader{r: r}, nil
+}
+
+type seqReader interface {
+	Read() (seq uint64, packet []byte, err error)
+	io.Closer
+}
+
+type byteSeqReader struct {
+	r *byteReadCloser
+}
+
+func (r *byteSeqReader) Read() (uint64, []byte, error) {
+	p, err := r.r.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(p) < 8 {
+		return 0, nil, errors.New("invalid packet")
+	}
+	return binary.BigEndian.Uint64(p), p[8:], nil
+}
+
+func (r *byteSeqReader) Close() error {
+	return r.r.Close()
+}
+
+type textSeqReader struct {
+	r *textReadCloser
+}
+
+func (r *textSeqReader) Read() (uint64, []byte, error) {
+	p, err := r.r.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+	i := strings.IndexByte(p, ':')
+	if i < 0 {
+		return 0, nil, errors.New("invalid packet")
+	}
+	seq, err := strconv.ParseUint(p[:i], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse sequence: %w", err)
+	}
+	return seq, []byte(p[i+1:]), nil
+}
+
+func (r *textSeqReader) Close() error {
+	return r.r.Close()
+}
+
+type reconnector struct {
+	client  *http.Client
+	req     *http.Request
+	options ReconnectOptions
+	newRead func(resp *http.Response) seqReader
+
+	id      string
+	seq     uint64
+	attempt int
+	// cookies set by server keep the session, which owns the stream
+	cookies []*http.Cookie
+
+	mu     sync.Mutex
+	r      seqReader
+	closed bool
+	ended  bool
+}
+
+func newReconnector(client *http.Client, req *http.Request, options *ReconnectOptions, newRead func(*http.Response) seqReader) *reconnector {
+	return &reconnector{
+		client:  client,
+		req:     req,
+		options: options.withDefaults(),
+		newRead: newRead,
+	}
+}
+
+// Read returns the next packet, reconnecting if connection is broken
+func (c *reconnector) Read() ([]byte, error) {
+	for {
+		c.mu.Lock()
+		r, closed, ended := c.r, c.closed, c.ended
+		c.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if ended {
+			return nil, io.EOF
+		}
+		if r == nil {
+			if err := c.connectWithRetry(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		seq, p, err := r.Read()
+		if err != nil {
+			log.Debugf("Read stream %s: %v", c.id, err)
+			r.Close()
+			c.mu.Lock()
+			c.r = nil
+			c.mu.Unlock()
+			continue
+		}
+		c.attempt = 0
+		if seq == endSeq {
+			c.mu.Lock()
+			c.ended = true
+			c.mu.Unlock()
+			r.Close()
+			return nil, io.EOF
+		}
+		if seq <= c.seq {
+			// Duplicate packet
+			continue
+		}
+		c.seq = seq
+		return p, nil
+	}
+}
+
+func (c *reconnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.r != nil {
+		return c.r.Close()
+	}
+	return nil
+}
+
+func (c *reconnector) connectWithRetry() error {
+	ctx := c.req.Context()
+	for {
+		if c.attempt > 0 {
+			select {
+			case <-time.After(c.options.backoff(c.attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err := c.connect(ctx)
+		if err == nil {
+			return nil
+		}
+		c.attempt++
+		if !isRetriable(err) || (c.options.MaxAttempts > 0 && c.attempt >= c.options.MaxAttempts) {
+			return err
+		}
+		log.Warnf("Connect stream %s: %v, attempt=%d", c.id, err, c.attempt)
+	}
+}
+
+func (c *reconnector) connect(ctx context.Context) error {
+	req := c.req.Clone(ctx)
+	if c.id != "" {
+		req.Header.Set(HeaderStreamID, c.id)
+		req.Header.Set(HeaderStreamSeq, fmt.Sprint(c.seq))
+		for _, cookie := range c.cookies {
+			if _, err := req.Cookie(cookie.Name); err != nil {
+				req.AddCookie(cookie)
+			}
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err = api.ParseResult(resp, nil, true); err != nil {
+			return fmt.Errorf("parse result: %w", err)
+		}
+		return types.NewError(resp.StatusCode, "unknown error")
+	}
+	id := resp.Header.Get(HeaderStreamID)
+	ack, err := strconv.ParseUint(resp.Header.Get(HeaderStreamSeq), 10, 64)
+	if id == "" || err != nil || ack != c.seq {
+		resp.Body.Close()
+		return types.NewError(http.StatusNotAcceptable, "stream is not resumable")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		resp.Body.Close()
+		return ErrClosed
+	}
+	if c.id == "" && c.client.Jar == nil {
+		c.cookies = resp.Cookies()
+	}
+	c.id = id
+	c.r = c.newRead(resp)
+	return nil
+}
+
+// isRetriable returns false for client errors, e.g. expired stream or unauthorized request
+func isRetriable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrClosed) {
+		return false
+	}
+	var e *types.Error
+	if errors.As(err, &e) {
+		code := e.Code
+		return code < 400 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+type reconnectingByteReader struct {
+	r *reconnector
+}
+
+func (r *reconnectingByteReader) Read() ([]byte, error) {
+	return r.r.Read()
+}
+
+func (r *reconnectingByteReader) Close() error {
+	return r.r.Close()
+}
+
+type reconnectingTextReader struct {
+	r *reconnector
+}
+
+func (r *reconnectingTextReader) Read() (string, error) {
+	p, err := r.r.Read()
+	if err != nil {
+		return "", err
+	}
+	return string(p), nil
+}
+
+func (r *reconnectingTextReader) Close() error {
+	return r.r.Close()
+}
+
+type reconnectingJSONReader struct {
+	r *reconnector
+}
+
+func (r *reconnectingJSONReader) Read(v interface{}) error {
+	p, err := r.r.Read()
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(p, v); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	return nil
+}
+
+func (r *reconnectingJSONReader) Close() error {
+	return r.r.Close()
+}
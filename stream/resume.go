@@ -0,0 +1,433 @@
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gopub/log"
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+)
+
+// Header keys of resumable streams
+const (
+	// HeaderStreamID identifies a resumable stream across connections
+	HeaderStreamID = "X-Stream-ID"
+	// HeaderStreamSeq is the sequence number of last received packet in request,
+	// and the acknowledged sequence number after which packets are sent in response
+	HeaderStreamSeq = "X-Stream-Seq"
+)
+
+// endSeq marks the end of a resumable stream. Sequence numbers of packets start from 1
+const endSeq = 0
+
+var ErrClosed = errors.New("stream closed")
+
+// ResumeOptions configures resumable streams
+type ResumeOptions struct {
+	// BufferSize is the number of latest packets retained for replay, default is 256
+	BufferSize int
+	// Timeout is the duration to wait for client to reconnect, default is 30 seconds.
+	// Context of serve function is canceled after timeout
+	Timeout time.Duration
+	// Heartbeat is the interval of sending heartbeat packets, default is DefaultHeartbeat
+	Heartbeat time.Duration
+}
+
+func (o *ResumeOptions) withDefaults() ResumeOptions {
+	var opts ResumeOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 256
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = DefaultHeartbeat
+	}
+	return opts
+}
+
+// NewResumableByteHandler serves a byte stream which outlives connections.
+// Every packet is stamped with a sequence number, and missed packets are replayed to reconnecting client.
+// Use NewReconnectingByteReader to read it
+func NewResumableByteHandler(options *ResumeOptions, serve func(context.Context, ByteWriteCloser)) wine.Handler {
+	h := newResumableHandler(options, mime.OctetStream, encodeBytePacket)
+	h.serve = func(ctx context.Context, s *session) {
+		serve(ctx, &byteSessionWriter{s: s})
+	}
+	return h
+}
+
+// NewResumableTextHandler is similar with NewResumableByteHandler. Use NewReconnectingTextReader to read it
+func NewResumableTextHandler(options *ResumeOptions, serve func(context.Context, TextWriteCloser)) wine.Handler {
+	h := newResumableHandler(options, mime.PlainUTF8, encodeTextPacket)
+	h.serve = func(ctx context.Context, s *session) {
+		serve(ctx, &textSessionWriter{s: s})
+	}
+	return h
+}
+
+// NewResumableJSONHandler is similar with NewResumableByteHandler. Use NewReconnectingJSONReader to read it
+func NewResumableJSONHandler(options *ResumeOptions, serve func(context.Context, JSONWriteCloser)) wine.Handler {
+	h := newResumableHandler(options, mime.JsonUTF8, encodeTextPacket)
+	h.serve = func(ctx context.Context, s *session) {
+		serve(ctx, &jsonSessionWriter{s: s})
+	}
+	return h
+}
+
+type seqPacket struct {
+	seq  uint64
+	data []byte
+}
+
+func encodeBytePacket(seq uint64, p []byte) []byte {
+	b := make([]byte, packetHeadLen+8+len(p))
+	binary.BigEndian.PutUint32(b, uint32(8+len(p)))
+	binary.BigEndian.PutUint64(b[packetHeadLen:], seq)
+	copy(b[packetHeadLen+8:], p)
+	return b
+}
+
+func encodeTextPacket(seq uint64, p []byte) []byte {
+	b := make([]byte, 0, len(p)+22)
+	b = strconv.AppendUint(b, seq, 10)
+	b = append(b, ':')
+	b = append(b, p...)
+	return append(b, textPacketDelimiter)
+}
+
+type resumableHandler struct {
+	serve       func(ctx context.Context, s *session)
+	options     ResumeOptions
+	contentType string
+	encode      func(seq uint64, p []byte) []byte
+	heartbeat   []byte
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newResumableHandler(options *ResumeOptions, contentType string, encode func(uint64, []byte) []byte) *resumableHandler {
+	h := &resumableHandler{
+		options:     options.withDefaults(),
+		contentType: contentType,
+		encode:      encode,
+		sessions:    make(map[string]*session),
+	}
+	if contentType == mime.OctetStream {
+		h.heartbeat = make([]byte, packetHeadLen)
+		binary.BigEndian.PutUint32(h.heartbeat, byteHeartbeat)
+	} else {
+		h.heartbeat = append([]byte(textHeartbeat), textPacketDelimiter)
+	}
+	return h
+}
+
+func (h *resumableHandler) HandleRequest(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	logger := log.FromContext(ctx)
+	header := req.Request().Header
+	var s *session
+	var lastSeq uint64
+	var started bool
+	if id := header.Get(HeaderStreamID); id != "" {
+		h.mu.Lock()
+		s = h.sessions[id]
+		h.mu.Unlock()
+		if s == nil {
+			return wine.Text(http.StatusGone, "stream expired")
+		}
+		// Stream id isn't a credential, only the client who started the stream can resume it
+		if s.owner != streamOwner(ctx) {
+			logger.Warnf("Reject resuming stream %s by another client", s.id)
+			return wine.Text(http.StatusForbidden, "stream is owned by another client")
+		}
+		seq, err := strconv.ParseUint(header.Get(HeaderStreamSeq), 10, 64)
+		if err != nil {
+			return wine.Text(http.StatusBadRequest, "invalid "+HeaderStreamSeq)
+		}
+		lastSeq = seq
+	} else {
+		s = h.newSession(ctx)
+		started = true
+		logger.Debugf("Start stream %s", s.id)
+	}
+
+	w := wine.GetResponseWriter(ctx)
+	w.Header().Set(mime.ContentType, h.contentType)
+	announceHeartbeat(w, h.options.Heartbeat)
+	detached, err := s.attach(w, lastSeq)
+	if err != nil {
+		logger.Errorf("Resume stream %s: %v", s.id, err)
+		return wine.Text(http.StatusGone, err.Error())
+	}
+	if started {
+		// Serve after attaching, so that packets written before attaching are all sent
+		go func() {
+			defer s.close()
+			h.serve(s.ctx, s)
+		}()
+	}
+	var tick <-chan time.Time
+	if h.options.Heartbeat > 0 {
+		ticker := time.NewTicker(h.options.Heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-detached:
+			return wine.Status(http.StatusOK)
+		case <-req.Request().Context().Done():
+			s.detach(w)
+			return wine.Status(http.StatusOK)
+		case <-tick:
+			s.heartbeat(w)
+		}
+	}
+}
+
+func (h *resumableHandler) newSession(ctx context.Context) *session {
+	s := &session{
+		h:     h,
+		id:    strings.ReplaceAll(uuid.New().String(), "-", ""),
+		owner: streamOwner(ctx),
+	}
+	// Stream outlives the request
+	s.ctx, s.cancel = context.WithCancel(wine.DetachContext(ctx))
+	h.mu.Lock()
+	h.sessions[s.id] = s
+	h.mu.Unlock()
+	return s
+}
+
+// streamOwner identifies the client by user if it's authenticated, otherwise by session id
+func streamOwner(ctx context.Context) string {
+	if id := wine.GetUserID(ctx); id > 0 {
+		return fmt.Sprint("user:", id)
+	}
+	if u := wine.GetUser(ctx); u != nil {
+		return fmt.Sprintf("user:%v", u)
+	}
+	return "session:" + wine.GetSessionID(ctx)
+}
+
+func (h *resumableHandler) removeSession(id string) {
+	h.mu.Lock()
+	delete(h.sessions, id)
+	h.mu.Unlock()
+}
+
+// session is a resumable stream which can be attached by one connection at a time
+type session struct {
+	h      *resumableHandler
+	id     string
+	owner  string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	seq      uint64
+	packets  []seqPacket
+	w        http.ResponseWriter
+	detached chan struct{}
+	ended    bool
+	timer    *time.Timer
+}
+
+func (s *session) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended || s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	s.seq++
+	s.packets = append(s.packets, seqPacket{seq: s.seq, data: p})
+	if n := len(s.packets) - s.h.options.BufferSize; n > 0 {
+		s.packets = s.packets[n:]
+	}
+	if s.w != nil {
+		if err := s.send(s.seq, p); err != nil {
+			// Keep the packet for replay
+			s.detachLocked()
+		}
+	}
+	return nil
+}
+
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if s.w != nil {
+		if err := s.send(endSeq, nil); err == nil {
+			s.w = nil
+			close(s.detached)
+			s.h.removeSession(s.id)
+			s.cancel()
+			return
+		}
+		s.detachLocked()
+	}
+	// Wait for client to receive the end of stream
+}
+
+// attach sends packets after lastSeq to w, then sends new packets to w until it's detached
+func (s *session) attach(w http.ResponseWriter, lastSeq uint64) (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return nil, ErrClosed
+	}
+	first := s.seq + 1
+	if len(s.packets) > 0 {
+		first = s.packets[0].seq
+	}
+	if lastSeq > s.seq || lastSeq+1 < first {
+		return nil, fmt.Errorf("cannot resume from %d, available packets are [%d, %d]", lastSeq, first, s.seq)
+	}
+	if s.w != nil {
+		// Supersede the previous connection
+		s.w = nil
+		close(s.detached)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	w.Header().Set(HeaderStreamID, s.id)
+	w.Header().Set(HeaderStreamSeq, fmt.Sprint(lastSeq))
+	w.WriteHeader(http.StatusOK)
+	s.w = w
+	s.detached = make(chan struct{})
+	detached := s.detached
+	for _, p := range s.packets {
+		if p.seq <= lastSeq {
+			continue
+		}
+		if err := s.send(p.seq, p.data); err != nil {
+			s.detachLocked()
+			return detached, nil
+		}
+	}
+	if s.ended {
+		if err := s.send(endSeq, nil); err == nil {
+			s.h.removeSession(s.id)
+			s.cancel()
+		}
+		s.detachLocked()
+		return detached, nil
+	}
+	// Flush header
+	if err := writeFlush(w, nil); err != nil {
+		s.detachLocked()
+	}
+	return detached, nil
+}
+
+func (s *session) detach(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == w {
+		s.detachLocked()
+	}
+}
+
+func (s *session) detachLocked() {
+	if s.w == nil {
+		return
+	}
+	s.w = nil
+	close(s.detached)
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.timer = time.AfterFunc(s.h.options.Timeout, s.expire)
+}
+
+func (s *session) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != nil {
+		return
+	}
+	log.Debugf("Stream %s expired", s.id)
+	s.h.removeSession(s.id)
+	s.cancel()
+}
+
+func (s *session) send(seq uint64, p []byte) error {
+	return writeFlush(s.w, s.h.encode(seq, p))
+}
+
+func (s *session) heartbeat(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != w {
+		return
+	}
+	if err := writeFlush(w, s.h.heartbeat); err != nil {
+		s.detachLocked()
+	}
+}
+
+type byteSessionWriter struct {
+	s *session
+}
+
+func (w *byteSessionWriter) Write(packet []byte) error {
+	p := make([]byte, len(packet))
+	copy(p, packet)
+	return w.s.write(p)
+}
+
+func (w *byteSessionWriter) Close() error {
+	w.s.close()
+	return nil
+}
+
+type textSessionWriter struct {
+	s *session
+}
+
+func (w *textSessionWriter) Write(s string) error {
+	return w.s.write([]byte(s))
+}
+
+func (w *textSessionWriter) Close() error {
+	w.s.close()
+	return nil
+}
+
+type jsonSessionWriter struct {
+	s *session
+}
+
+func (w *jsonSessionWriter) Write(v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return w.s.write(p)
+}
+
+func (w *jsonSessionWriter) Close() error {
+	w.s.close()
+	return nil
+}
@@ -0,0 +1,243 @@
+package stream_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/stream"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumableStream(t *testing.T) {
+	next := make(chan string)
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "text", stream.NewResumableTextHandler(nil, func(ctx context.Context, w stream.TextWriteCloser) {
+		defer w.Close()
+		for v := range next {
+			require.NoError(t, w.Write(v))
+		}
+	}))
+	s.Bind(http.MethodGet, "json", stream.NewResumableJSONHandler(nil, func(ctx context.Context, w stream.JSONWriteCloser) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, w.Write(i))
+		}
+		w.Close()
+	}))
+	s.Bind(http.MethodGet, "bytes", stream.NewResumableByteHandler(nil, func(ctx context.Context, w stream.ByteWriteCloser) {
+		require.NoError(t, w.Write([]byte{0, 1}))
+		require.NoError(t, w.Write(nil))
+		w.Close()
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	options := &stream.ReconnectOptions{MinBackoff: 10 * time.Millisecond}
+
+	t.Run("Resume", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/text", nil)
+		require.NoError(t, err)
+		r, err := stream.NewReconnectingTextReader(http.DefaultClient, req, options)
+		require.NoError(t, err)
+		defer r.Close()
+
+		next <- "a"
+		v, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, "a", v)
+
+		// Packets written during disconnection are replayed
+		ts.CloseClientConnections()
+		next <- "b"
+		next <- "c"
+		for _, s := range []string{"b", "c"} {
+			v, err = r.Read()
+			require.NoError(t, err)
+			require.Equal(t, s, v)
+		}
+		close(next)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/json", nil)
+		require.NoError(t, err)
+		r, err := stream.NewReconnectingJSONReader(http.DefaultClient, req, options)
+		require.NoError(t, err)
+		defer r.Close()
+		for i := 0; i < 3; i++ {
+			var v int
+			require.NoError(t, r.Read(&v))
+			require.Equal(t, i, v)
+		}
+		var v int
+		require.Equal(t, io.EOF, r.Read(&v))
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/bytes", nil)
+		require.NoError(t, err)
+		r, err := stream.NewReconnectingByteReader(http.DefaultClient, req, options)
+		require.NoError(t, err)
+		defer r.Close()
+		p, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 1}, p)
+		p, err = r.Read()
+		require.NoError(t, err)
+		require.Empty(t, p)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/text", nil)
+		require.NoError(t, err)
+		req.Header.Set(stream.HeaderStreamID, "unknown")
+		req.Header.Set(stream.HeaderStreamSeq, "1")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusGone, resp.StatusCode)
+	})
+}
+
+func TestResumableStreamTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	wrote := make(chan struct{})
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewResumableTextHandler(&stream.ResumeOptions{
+		BufferSize: 2,
+		Timeout:    50 * time.Millisecond,
+	}, func(ctx context.Context, w stream.TextWriteCloser) {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, w.Write(fmt.Sprint(i)))
+		}
+		close(wrote)
+		<-ctx.Done()
+		require.Equal(t, stream.ErrClosed, w.Write(""))
+		close(canceled)
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	id := resp.Header.Get(stream.HeaderStreamID)
+	require.NotEmpty(t, id)
+	<-wrote
+	resp.Body.Close()
+
+	// Packets 1-3 are evicted from buffer
+	for _, c := range resp.Cookies() {
+		req.AddCookie(c)
+	}
+	req.Header.Set(stream.HeaderStreamID, id)
+	req.Header.Set(stream.HeaderStreamSeq, "1")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusGone, resp.StatusCode)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("stream isn't expired")
+	}
+}
+
+func TestResumableStreamBuffer(t *testing.T) {
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewResumableTextHandler(&stream.ResumeOptions{BufferSize: 2}, func(ctx context.Context, w stream.TextWriteCloser) {
+		// Packets more than buffer size are written before the first connection is attached
+		for i := 0; i < 5; i++ {
+			require.NoError(t, w.Write(fmt.Sprint(i)))
+		}
+		w.Close()
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for i := 0; i < 10; i++ {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		r, err := stream.NewReconnectingTextReader(http.DefaultClient, req, nil)
+		require.NoError(t, err)
+		for j := 0; j < 5; j++ {
+			v, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprint(j), v)
+		}
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+		r.Close()
+	}
+}
+
+func TestResumableStreamOwner(t *testing.T) {
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewResumableTextHandler(nil, func(ctx context.Context, w stream.TextWriteCloser) {
+		require.NoError(t, w.Write("a"))
+		<-ctx.Done()
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	id := resp.Header.Get(stream.HeaderStreamID)
+	require.NotEmpty(t, id)
+	cookies := resp.Cookies()
+	require.NotEmpty(t, cookies)
+
+	resume := func(cookies []*http.Cookie) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set(stream.HeaderStreamID, id)
+		req.Header.Set(stream.HeaderStreamSeq, "1")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Stream id is leaked to another client
+	require.Equal(t, http.StatusForbidden, resume(nil))
+	require.Equal(t, http.StatusOK, resume(cookies))
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	var attempts int32
+	s := wine.NewServer()
+	s.Get("/", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		atomic.AddInt32(&attempts, 1)
+		return wine.Status(http.StatusServiceUnavailable)
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = stream.NewReconnectingTextReader(http.DefaultClient, req, &stream.ReconnectOptions{
+		MinBackoff:  20 * time.Millisecond,
+		MaxAttempts: 4,
+	})
+	require.Error(t, err)
+	require.Equal(t, int32(4), atomic.LoadInt32(&attempts))
+	// Delays are at least 10ms, 20ms and 40ms
+	require.True(t, time.Since(start) >= 70*time.Millisecond)
+}
@@ -3,8 +3,13 @@ package stream
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/gopub/environ"
+	"github.com/gopub/types"
+	"github.com/gopub/wine/api"
+
 	"github.com/gopub/wine"
 
 	"github.com/gopub/log"
@@ -12,8 +17,91 @@ import (
 
 const (
 	Greeting = "WINE"
+	// HeaderStreamHeartbeat announces the heartbeat interval in response.
+	// Readers skip heartbeat packets only if it's present, so that streams without heartbeat keep the original format
+	HeaderStreamHeartbeat = "X-Stream-Heartbeat"
 )
 
+// DefaultHeartbeat is the interval of sending heartbeat packets, which keep idle connections alive through proxies.
+// Heartbeat is disabled by default, as readers of earlier versions can't recognize heartbeat packets
+var DefaultHeartbeat = environ.Duration("wine.stream.heartbeat", 0)
+
+type streamWriter interface {
+	heartbeat() error
+	// detach makes writer reject writes after handler returns
+	detach()
+}
+
+// announceHeartbeat sets HeaderStreamHeartbeat if heartbeat is enabled. It must be called before writing response
+func announceHeartbeat(w http.ResponseWriter, interval time.Duration) {
+	if interval > 0 {
+		w.Header().Set(HeaderStreamHeartbeat, interval.String())
+	}
+}
+
+// hasHeartbeat returns true if server of resp sends heartbeat packets
+func hasHeartbeat(resp *http.Response) bool {
+	return resp.Header.Get(HeaderStreamHeartbeat) != ""
+}
+
+// run calls serve in a new goroutine, and sends heartbeat packets until serve closes w or client disconnects.
+// ctx of serve outlives server's timeout, and it's canceled on return
+func run(ctx context.Context, req *wine.Request, w streamWriter, done <-chan interface{}, serve func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(wine.DetachContext(ctx))
+	defer cancel()
+	defer w.detach()
+	go serve(ctx)
+	var tick <-chan time.Time
+	if DefaultHeartbeat > 0 {
+		ticker := time.NewTicker(DefaultHeartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-req.Request().Context().Done():
+			return
+		case <-tick:
+			if err := w.heartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// openStream sends req and returns the response if status is OK
+func openStream(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = api.ParseResult(resp, nil, true)
+		if err != nil {
+			return nil, fmt.Errorf("parse result: %w", err)
+		}
+		return nil, types.NewError(resp.StatusCode, "unknown error")
+	}
+	return resp, nil
+}
+
+func writeFlush(w http.ResponseWriter, b []byte) error {
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	if e, ok := w.(interface{ Error() error }); ok {
+		if e.Error() != nil {
+			return e.Error()
+		}
+	}
+	return nil
+}
+
 func InstallDebugRotes(r *wine.Router) {
 	r.Get("bytestream", NewByteHandler(debugByteStream).(wine.HandlerFunc))
 	r.Get("textstream", NewTextHandler(debugTextStream).(wine.HandlerFunc))
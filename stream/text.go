@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
 	"github.com/gopub/types"
 
@@ -17,6 +18,10 @@ import (
 
 const textPacketDelimiter = 0x01
 
+// textHeartbeat is a packet which is skipped by reader if server announces heartbeat.
+// Don't write it as payload if heartbeat is enabled
+const textHeartbeat = "\x02"
+
 type TextReadCloser interface {
 	Read() (string, error)
 	io.Closer
@@ -32,11 +37,15 @@ type textReadCloser struct {
 	buf   *bytes.Buffer
 	block []byte
 	err   error
+
+	// heartbeat is true if heartbeat packets should be skipped
+	heartbeat bool
 }
 
-func newTextReadCloser(body io.ReadCloser) *textReadCloser {
+func newTextReadCloser(body io.ReadCloser, heartbeat bool) *textReadCloser {
 	r := new(textReadCloser)
 	r.body = body
+	r.heartbeat = heartbeat
 	r.buf = new(bytes.Buffer)
 	r.block = make([]byte, 1024)
 	return r
@@ -46,6 +55,9 @@ func (r *textReadCloser) Read() (string, error) {
 	for {
 		p, ok := r.readPacket()
 		if ok {
+			if p == textHeartbeat && r.heartbeat {
+				continue
+			}
 			return p, nil
 		}
 		if r.err != nil {
@@ -81,8 +93,10 @@ func (r *textReadCloser) readPacket() (string, bool) {
 }
 
 type textWriteCloser struct {
-	w    http.ResponseWriter
-	done chan<- interface{}
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	done   chan<- interface{}
+	closed bool
 }
 
 func newTextWriteCloser(w http.ResponseWriter, done chan<- interface{}) *textWriteCloser {
@@ -95,26 +109,38 @@ func newTextWriteCloser(w http.ResponseWriter, done chan<- interface{}) *textWri
 func (w *textWriteCloser) Write(s string) error {
 	p := []byte(s)
 	p = append(p, textPacketDelimiter)
-	_, err := w.w.Write(p)
-	if err != nil {
-		return fmt.Errorf("write all: %w", err)
-	}
-	if flusher, ok := w.w.(http.Flusher); ok {
-		flusher.Flush()
-	}
-	if e, ok := w.w.(interface{ Error() error }); ok {
-		if e.Error() != nil {
-			return e.Error()
-		}
-	}
-	return nil
+	return w.write(p)
 }
 
 func (w *textWriteCloser) Close() error {
-	close(w.done)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
 	return nil
 }
 
+func (w *textWriteCloser) heartbeat() error {
+	return w.Write(textHeartbeat)
+}
+
+func (w *textWriteCloser) detach() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+}
+
+func (w *textWriteCloser) write(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	return writeFlush(w.w, b)
+}
+
 func NewTextReader(client *http.Client, req *http.Request) (TextReadCloser, error) {
 	resp, err := client.Do(req)
 	if err != nil {
@@ -127,7 +153,7 @@ func NewTextReader(client *http.Client, req *http.Request) (TextReadCloser, erro
 		}
 		return nil, types.NewError(resp.StatusCode, "unknown error")
 	}
-	r := newTextReadCloser(resp.Body)
+	r := newTextReadCloser(resp.Body, hasHeartbeat(resp))
 	greeting, err := r.Read()
 	if err != nil {
 		r.Close()
@@ -140,6 +166,7 @@ func NewTextReader(client *http.Client, req *http.Request) (TextReadCloser, erro
 	return r, nil
 }
 
+// NewTextHandler serves a text stream. ctx of serve is canceled once client disconnects
 func NewTextHandler(serve func(context.Context, TextWriteCloser)) wine.Handler {
 	return wine.HandlerFunc(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
 		logger := log.FromContext(ctx)
@@ -147,6 +174,7 @@ func NewTextHandler(serve func(context.Context, TextWriteCloser)) wine.Handler {
 		defer logger.Debugf("Closed")
 		w := wine.GetResponseWriter(ctx)
 		w.Header().Set(mime.ContentType, mime.HtmlUTF8)
+		announceHeartbeat(w, DefaultHeartbeat)
 		done := make(chan interface{})
 		tw := newTextWriteCloser(w, done)
 		err := tw.Write(Greeting)
@@ -154,8 +182,9 @@ func NewTextHandler(serve func(context.Context, TextWriteCloser)) wine.Handler {
 			logger.Errorf("Handshake: %v", err)
 			return wine.Status(http.StatusOK)
 		}
-		go serve(ctx, tw)
-		<-done
+		run(ctx, req, tw, done, func(ctx context.Context) {
+			serve(ctx, tw)
+		})
 		return wine.Status(http.StatusOK)
 	})
 }
@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+)
+
+// Writer writes values of T, each value is encoded with codec into a packet
+type Writer[T any] struct {
+	w     ByteWriteCloser
+	codec Codec
+}
+
+func NewWriter[T any](w ByteWriteCloser, codec Codec) *Writer[T] {
+	return &Writer[T]{
+		w:     w,
+		codec: codec,
+	}
+}
+
+func (w *Writer[T]) Write(v T) error {
+	p, err := w.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return w.w.Write(p)
+}
+
+func (w *Writer[T]) Close() error {
+	return w.w.Close()
+}
+
+// Reader reads values of T written by Writer with the same codec
+type Reader[T any] struct {
+	r     ByteReadCloser
+	codec Codec
+	// elem is set if T is a pointer type, e.g. *pb.Event, which is allocated before decoding
+	elem reflect.Type
+}
+
+func NewReader[T any](r ByteReadCloser, codec Codec) *Reader[T] {
+	reader := &Reader[T]{
+		r:     r,
+		codec: codec,
+	}
+	if t := reflect.TypeOf((*T)(nil)).Elem(); t.Kind() == reflect.Ptr {
+		reader.elem = t.Elem()
+	}
+	return reader
+}
+
+func (r *Reader[T]) Read() (T, error) {
+	var v T
+	p, err := r.r.Read()
+	if err != nil {
+		return v, err
+	}
+	if r.elem != nil {
+		v = reflect.New(r.elem).Interface().(T)
+		err = r.codec.Unmarshal(p, v)
+	} else {
+		err = r.codec.Unmarshal(p, &v)
+	}
+	if err != nil {
+		return v, fmt.Errorf("unmarshal: %w", err)
+	}
+	return v, nil
+}
+
+func (r *Reader[T]) Close() error {
+	return r.r.Close()
+}
+
+// NewHandler serves a stream of T in length-prefixed packets, so that values can contain arbitrary bytes.
+// Content-Type of response is codec's content type. Use Open to read it
+func NewHandler[T any](codec Codec, serve func(context.Context, *Writer[T])) wine.Handler {
+	return newByteHandler(codec.ContentType(), func(ctx context.Context, w ByteWriteCloser) {
+		serve(ctx, NewWriter[T](w, codec))
+	})
+}
+
+// Open reads a stream served by NewHandler
+func Open[T any](client *http.Client, req *http.Request, codec Codec) (*Reader[T], error) {
+	resp, err := openStream(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if t := mime.GetContentType(resp.Header); t != codec.ContentType() {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expect %s, got %s", codec.ContentType(), t)
+	}
+	r := newByteReadCloser(resp.Body, hasHeartbeat(resp))
+	if err = handshakeBytes(r); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return NewReader[T](r, codec), nil
+}
@@ -0,0 +1,114 @@
+package stream_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/stream"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type typedPacket struct {
+	Name  string
+	Score int
+}
+
+func TestTypedStream(t *testing.T) {
+	packets := []typedPacket{
+		{"tom", 80},
+		// Delimiter of text stream
+		{"\x01\n", 70},
+	}
+	s := wine.NewServer()
+	for _, codec := range []stream.Codec{stream.JSONCodec, stream.MsgPackCodec} {
+		s.Bind(http.MethodGet, codec.ContentType(), stream.NewHandler(codec, func(ctx context.Context, w *stream.Writer[typedPacket]) {
+			defer w.Close()
+			for _, p := range packets {
+				require.NoError(t, w.Write(p))
+			}
+		}))
+	}
+	s.Bind(http.MethodGet, "protobuf", stream.NewHandler(stream.ProtobufCodec, func(ctx context.Context, w *stream.Writer[*wrapperspb.StringValue]) {
+		defer w.Close()
+		require.NoError(t, w.Write(wrapperspb.String("hello")))
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for _, codec := range []stream.Codec{stream.JSONCodec, stream.MsgPackCodec} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/"+codec.ContentType(), nil)
+			require.NoError(t, err)
+			r, err := stream.Open[typedPacket](http.DefaultClient, req, codec)
+			require.NoError(t, err)
+			defer r.Close()
+			for _, p := range packets {
+				v, err := r.Read()
+				require.NoError(t, err)
+				require.Equal(t, p, v)
+			}
+			_, err = r.Read()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+
+	t.Run("Protobuf", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/protobuf", nil)
+		require.NoError(t, err)
+		r, err := stream.Open[*wrapperspb.StringValue](http.DefaultClient, req, stream.ProtobufCodec)
+		require.NoError(t, err)
+		defer r.Close()
+		v, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, "hello", v.GetValue())
+	})
+
+	t.Run("CodecMismatch", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/protobuf", nil)
+		require.NoError(t, err)
+		_, err = stream.Open[typedPacket](http.DefaultClient, req, stream.JSONCodec)
+		require.Error(t, err)
+	})
+}
+
+func TestNDJSONStream(t *testing.T) {
+	s := wine.NewServer()
+	s.Bind(http.MethodGet, "/", stream.NewNDJSONHandler(func(ctx context.Context, w *stream.Writer[typedPacket]) {
+		defer w.Close()
+		require.NoError(t, w.Write(typedPacket{"tom", 80}))
+		require.NoError(t, w.Write(typedPacket{"jim\njohn", 70}))
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Raw", func(t *testing.T) {
+		resp, err := http.Get(ts.URL)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, `{"Name":"tom","Score":80}`+"\n"+`{"Name":"jim\njohn","Score":70}`+"\n", string(b))
+	})
+
+	t.Run("Reader", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		r, err := stream.OpenNDJSON[typedPacket](http.DefaultClient, req)
+		require.NoError(t, err)
+		defer r.Close()
+		v, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, typedPacket{"tom", 80}, v)
+		v, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, typedPacket{"jim\njohn", 70}, v)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+	})
+}
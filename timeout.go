@@ -0,0 +1,269 @@
+package wine
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HeaderRequestTimeout carries remaining time of caller's deadline in milliseconds.
+// Server responds 504 if it passes before response header is written
+const HeaderRequestTimeout = "X-Request-Timeout"
+
+// NoTimeout disables timeout of routes bound with it, e.g. long polling and streaming
+var NoTimeout Handler = timeoutHandler(0)
+
+// NewTimeoutHandler returns a handler which overrides Server.Timeout of routes bound with it.
+// The last one wins if a route is bound with multiple timeout handlers, e.g. by group and by route.
+// Zero or negative timeout is the same as NoTimeout
+func NewTimeoutHandler(timeout time.Duration) Handler {
+	if timeout < 0 {
+		timeout = 0
+	}
+	return timeoutHandler(timeout)
+}
+
+// TimeoutHandler is implemented by handlers which override Server.Timeout of routes bound with them,
+// e.g. event streams. Zero means no timeout
+type TimeoutHandler interface {
+	Handler
+	Timeout() time.Duration
+}
+
+type timeoutHandler time.Duration
+
+func (h timeoutHandler) HandleRequest(ctx context.Context, req *Request, next Invoker) Responder {
+	return next(ctx, req)
+}
+
+func (h timeoutHandler) Timeout() time.Duration {
+	return time.Duration(h)
+}
+
+func (h timeoutHandler) String() string {
+	if h == 0 {
+		return "wine.NoTimeout"
+	}
+	return fmt.Sprintf("wine.Timeout(%v)", time.Duration(h))
+}
+
+// requestTimeout returns timeout of req and the status responded once it passes
+func (s *Server) requestTimeout(handlers *list.List, req *http.Request) (time.Duration, int) {
+	timeout := s.Timeout
+	for e := handlers.Front(); e != nil; e = e.Next() {
+		if t, ok := e.Value.(TimeoutHandler); ok {
+			timeout = t.Timeout()
+		}
+	}
+	status := http.StatusServiceUnavailable
+	if ms, err := strconv.ParseInt(req.Header.Get(HeaderRequestTimeout), 10, 64); err == nil && ms > 0 {
+		if d := time.Duration(ms) * time.Millisecond; timeout <= 0 || d < timeout {
+			timeout = d
+			status = http.StatusGatewayTimeout
+		}
+	}
+	return timeout, status
+}
+
+// invokeWithTimeout calls invoke in a new goroutine. If timeout passes before response header is written,
+// responds status and abandons invoke, whose writes are rejected with http.ErrHandlerTimeout. Responder returned late
+// is still responded into the rejecting writer, so that it can release resources, e.g. slot of ConcurrencyLimiter.
+// Otherwise ctx is canceled and invoke is waited, e.g. streaming or hijacked connection
+func (s *Server) invokeWithTimeout(ctx context.Context, rw http.ResponseWriter, timeout time.Duration, status int,
+	invoke func(ctx context.Context) Responder) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tw := newTimeoutWriter(rw)
+	ctx = withResponseWriter(ctx, tw)
+	done := make(chan Responder, 1)
+	panicked := make(chan interface{}, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer func() {
+			if e := recover(); e != nil {
+				if tw.isTimedOut() {
+					logger.Errorf("Panic after timeout: %v", e)
+					return
+				}
+				panicked <- e
+			}
+		}()
+		done <- invoke(ctx)
+	}()
+	select {
+	case resp := <-done:
+		resp.Respond(ctx, tw)
+		return
+	case e := <-panicked:
+		panic(e)
+	case <-ctx.Done():
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && tw.timeout() {
+		Text(status, http.StatusText(status)).Respond(ctx, rw)
+		go respondLate(ctx, tw, done, finished)
+		return
+	}
+	select {
+	case resp := <-done:
+		resp.Respond(ctx, tw)
+	case e := <-panicked:
+		panic(e)
+	}
+}
+
+// respondLate responds Responder of the abandoned invoke once it finishes
+func respondLate(ctx context.Context, tw *timeoutWriter, done <-chan Responder, finished <-chan struct{}) {
+	<-finished
+	var resp Responder
+	select {
+	case resp = <-done:
+	default:
+		// Panicked
+		return
+	}
+	if resp == nil {
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			logger.Errorf("Panic after timeout: %v", e)
+		}
+	}()
+	resp.Respond(ctx, tw)
+}
+
+// timeoutWriter holds header of handler until it's written, so that response can be replaced once timed out
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	mu          sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+var (
+	_ http.Flusher  = (*timeoutWriter)(nil)
+	_ http.Hijacker = (*timeoutWriter)(nil)
+)
+
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{
+		w:      w,
+		header: w.Header().Clone(),
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		// Trailers
+		return w.w.Header()
+	}
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.writeHeader(statusCode)
+}
+
+func (w *timeoutWriter) writeHeader(statusCode int) {
+	if w.wroteHeader {
+		w.w.WriteHeader(statusCode)
+		return
+	}
+	w.wroteHeader = true
+	h := w.w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, v := range w.header {
+		h[k] = v
+	}
+	w.w.WriteHeader(statusCode)
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	return w.w.Write(data)
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	h, ok := w.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+// Error returns error of underlying writer, e.g. compression
+func (w *timeoutWriter) Error() error {
+	if e, ok := w.w.(interface{ Error() error }); ok {
+		return e.Error()
+	}
+	return nil
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+// timeout marks w as timed out if header isn't written
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return false
+	}
+	w.timedOut = true
+	return true
+}
+
+func (w *timeoutWriter) isTimedOut() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.timedOut
+}
@@ -0,0 +1,104 @@
+package wine_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/api"
+	"github.com/stretchr/testify/require"
+)
+
+func sleepHandler(d time.Duration) wine.HandlerFunc {
+	return func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		// Ignore ctx
+		time.Sleep(d)
+		return wine.Text(http.StatusOK, "done")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := wine.NewServer()
+	s.Timeout = 100 * time.Millisecond
+	s.Get("slow", sleepHandler(time.Second))
+	g := s.Group("reports").UseHandlers(wine.NewTimeoutHandler(time.Second))
+	g.Get("slow", sleepHandler(300*time.Millisecond))
+	g.Bind(http.MethodGet, "fast", wine.NewTimeoutHandler(50*time.Millisecond), sleepHandler(300*time.Millisecond))
+	s.UseHandlers(wine.NoTimeout).Get("poll", sleepHandler(300*time.Millisecond))
+	s.Get("stream", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		w := wine.GetResponseWriter(ctx)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+		return wine.ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {})
+	})
+	s.Get("deadline", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		if v := req.Request().Header.Get(wine.HeaderRequestTimeout); v != "" {
+			return wine.Text(http.StatusOK, v)
+		}
+		return wine.Text(http.StatusOK, "none")
+	})
+	addr := runServer(t, s)
+	defer s.Shutdown()
+
+	get := func(path string, header http.Header) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/"+path, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp.StatusCode, readBody(t, resp)
+	}
+
+	t.Run("Server", func(t *testing.T) {
+		start := time.Now()
+		status, _ := get("slow", nil)
+		require.Equal(t, http.StatusServiceUnavailable, status)
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+	t.Run("Group", func(t *testing.T) {
+		status, body := get("reports/slow", nil)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "done", body)
+	})
+	t.Run("Route", func(t *testing.T) {
+		status, _ := get("reports/fast", nil)
+		require.Equal(t, http.StatusServiceUnavailable, status)
+	})
+	t.Run("NoTimeout", func(t *testing.T) {
+		status, body := get("poll", nil)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "done", body)
+	})
+	t.Run("HeaderWritten", func(t *testing.T) {
+		status, body := get("stream", nil)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "done", body)
+	})
+	t.Run("Propagated", func(t *testing.T) {
+		status, _ := get("reports/slow", http.Header{wine.HeaderRequestTimeout: {"50"}})
+		require.Equal(t, http.StatusGatewayTimeout, status)
+	})
+	t.Run("Client", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		var v string
+		c := api.NewClient(http.DefaultClient)
+		err := c.Get(ctx, "http://"+addr+"/deadline", nil, &v)
+		require.NoError(t, err)
+		require.Equal(t, "none", v)
+
+		c.PropagateDeadline = true
+		err = c.Get(ctx, "http://"+addr+"/deadline", nil, &v)
+		require.NoError(t, err)
+		ms, err := strconv.Atoi(v)
+		require.NoError(t, err)
+		require.True(t, ms > 0 && ms <= 500)
+	})
+}
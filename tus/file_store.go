@@ -0,0 +1,202 @@
+package tus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gopub/types"
+)
+
+const (
+	dataFileExt = ".bin"
+	infoFileExt = ".info"
+)
+
+var _ Store = (*FileStore)(nil)
+
+// FileStore saves uploads in local file system.
+// Each upload has two files in dir: <id>.bin holds data, <id>.info holds json encoded Upload
+type FileStore struct {
+	dir   string
+	mu    sync.Mutex
+	locks map[string]*uploadLock
+}
+
+// uploadLock serializes operations on an upload. It's removed once no operation holds or waits for it
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("make dir %s: %w", dir, err)
+	}
+	return &FileStore{
+		dir:   dir,
+		locks: make(map[string]*uploadLock),
+	}, nil
+}
+
+// Path returns data file path of upload id
+func (s *FileStore) Path(id string) string {
+	return filepath.Join(s.dir, id+dataFileExt)
+}
+
+// Open opens data file of upload id for reading
+func (s *FileStore) Open(id string) (*os.File, error) {
+	f, err := os.Open(s.Path(id))
+	if os.IsNotExist(err) {
+		return nil, types.ErrNotExist
+	}
+	return f, err
+}
+
+func (s *FileStore) Create(ctx context.Context, u *Upload) error {
+	f, err := os.OpenFile(s.Path(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("create data file: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close data file: %w", err)
+	}
+	return s.writeInfo(u)
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Upload, error) {
+	defer s.lock(id)()
+	return s.readInfo(id)
+}
+
+func (s *FileStore) Update(ctx context.Context, u *Upload) error {
+	defer s.lock(u.ID)()
+	stored, err := s.readInfo(u.ID)
+	if err != nil {
+		return err
+	}
+	// Offset is maintained by Append only
+	stored.Length = u.Length
+	stored.ExpiresAt = u.ExpiresAt
+	stored.Metadata = u.Metadata
+	return s.writeInfo(stored)
+}
+
+func (s *FileStore) Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error) {
+	defer s.lock(id)()
+	u, err := s.readInfo(id)
+	if err != nil {
+		return 0, err
+	}
+	if u.Offset != offset {
+		return 0, ErrOffsetMismatch
+	}
+	f, err := os.OpenFile(s.Path(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("open data file: %w", err)
+	}
+	n, err := io.Copy(f, r)
+	if cErr := f.Close(); cErr != nil && err == nil {
+		err = fmt.Errorf("close data file: %w", cErr)
+	}
+	// Save received bytes even if transport failed, so that client can resume from here
+	u.Offset += n
+	if wErr := s.writeInfo(u); wErr != nil {
+		return n, wErr
+	}
+	return n, err
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	defer s.lock(id)()
+	err := os.Remove(filepath.Join(s.dir, id+infoFileExt))
+	if os.IsNotExist(err) {
+		return types.ErrNotExist
+	}
+	if err != nil {
+		return fmt.Errorf("remove info file: %w", err)
+	}
+	if err = os.Remove(s.Path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove data file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) List(ctx context.Context) ([]*Upload, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+	var l []*Upload
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), infoFileExt) {
+			continue
+		}
+		u, err := s.Get(ctx, strings.TrimSuffix(fi.Name(), infoFileExt))
+		if err != nil {
+			// Deleted by others
+			continue
+		}
+		l = append(l, u)
+	}
+	return l, nil
+}
+
+// lock locks upload id and returns the function to unlock it
+func (s *FileStore) lock(id string) func() {
+	s.mu.Lock()
+	l := s.locks[id]
+	if l == nil {
+		l = new(uploadLock)
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *FileStore) readInfo(id string) (*Upload, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, id+infoFileExt))
+	if os.IsNotExist(err) {
+		return nil, types.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read info file: %w", err)
+	}
+	u := new(Upload)
+	if err = json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("unmarshal info: %w", err)
+	}
+	return u, nil
+}
+
+func (s *FileStore) writeInfo(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("marshal info: %w", err)
+	}
+	// Write to a temp file then rename, so that info file is never half written
+	filename := filepath.Join(s.dir, u.ID+infoFileExt)
+	if err = ioutil.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("write info file: %w", err)
+	}
+	if err = os.Rename(filename+".tmp", filename); err != nil {
+		return fmt.Errorf("rename info file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package tus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gopub/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreLocks(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	t.Run("NotExist", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			_, err := s.Get(ctx, fmt.Sprint("unknown", i))
+			require.ErrorIs(t, err, types.ErrNotExist)
+		}
+		require.Empty(t, s.locks)
+	})
+
+	t.Run("AppendAndDelete", func(t *testing.T) {
+		require.NoError(t, s.Create(ctx, &Upload{ID: "a", Length: 100}))
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				s.Append(ctx, "a", int64(i), strings.NewReader("x"))
+			}(i)
+			go func() {
+				defer wg.Done()
+				s.Delete(ctx, "a")
+			}()
+		}
+		wg.Wait()
+		_, err := s.Get(ctx, "a")
+		require.ErrorIs(t, err, types.ErrNotExist)
+		require.Empty(t, s.locks)
+	})
+}
@@ -0,0 +1,48 @@
+package tus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrOffsetMismatch = errors.New("offset mismatch")
+
+// Upload describes state of a resumable upload
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"` // -1 if length is deferred
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// IsLengthDeferred returns true if client hasn't declared the length
+func (u *Upload) IsLengthDeferred() bool {
+	return u.Length < 0
+}
+
+// IsComplete returns true if all bytes have been received
+func (u *Upload) IsComplete() bool {
+	return u.Length >= 0 && u.Offset >= u.Length
+}
+
+// IsExpired returns true if upload is incomplete and has passed its expiry time
+func (u *Upload) IsExpired(now time.Time) bool {
+	return !u.IsComplete() && !u.ExpiresAt.IsZero() && now.After(u.ExpiresAt)
+}
+
+// Store persists uploads. Get, Append and Delete should return types.ErrNotExist if upload doesn't exist
+type Store interface {
+	Create(ctx context.Context, u *Upload) error
+	Get(ctx context.Context, id string) (*Upload, error)
+	// Update saves length and expiry time of u
+	Update(ctx context.Context, u *Upload) error
+	// Append writes data from r at offset, returns the number of written bytes.
+	// ErrOffsetMismatch is returned if offset isn't equal to current offset of upload
+	Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*Upload, error)
+}
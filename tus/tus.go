@@ -0,0 +1,342 @@
+// Package tus implements tus resumable upload protocol 1.0.0 (https://tus.io/protocols/resumable-upload.html)
+// Supported extensions: creation, creation-defer-length, termination and expiration
+package tus
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gopub/log"
+	"github.com/gopub/types"
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,creation-defer-length,termination,expiration"
+)
+
+// Header keys
+const (
+	HeaderTusResumable      = "Tus-Resumable"
+	HeaderTusVersion        = "Tus-Version"
+	HeaderTusExtension      = "Tus-Extension"
+	HeaderTusMaxSize        = "Tus-Max-Size"
+	HeaderUploadLength      = "Upload-Length"
+	HeaderUploadDeferLength = "Upload-Defer-Length"
+	HeaderUploadOffset      = "Upload-Offset"
+	HeaderUploadMetadata    = "Upload-Metadata"
+	HeaderUploadExpires     = "Upload-Expires"
+)
+
+var idRegexp = regexp.MustCompile(`^[0-9a-zA-Z_\-]+$`)
+
+// Handler serves tus protocol requests
+type Handler struct {
+	store Store
+
+	// MaxSize limits upload length. No limit if MaxSize is zero
+	MaxSize int64
+	// Expiration is the duration before an incomplete upload expires. Never expire if Expiration is zero
+	Expiration time.Duration
+	// OnComplete is called after all bytes of an upload have been received
+	OnComplete func(ctx context.Context, u *Upload)
+}
+
+func NewHandler(store Store) *Handler {
+	return &Handler{
+		store: store,
+	}
+}
+
+// Mount binds tus endpoints to r, e.g. h.Mount(s.Group("files"))
+// POST / creates an upload, HEAD/PATCH/DELETE /{id} query, append and terminate an upload
+func (h *Handler) Mount(r *wine.Router) {
+	r = r.Use(h.checkVersion)
+	r.Options("/", h.handleOptions)
+	r.Post("/", h.create)
+	r.Options("{id}", h.handleOptions)
+	r.Head("{id}", h.head)
+	// Appending large chunk may take longer than server timeout
+	r.UseHandlers(wine.NoTimeout).Patch("{id}", h.patch)
+	r.Delete("{id}", h.delete)
+}
+
+// RemoveExpired deletes all expired uploads
+func (h *Handler) RemoveExpired(ctx context.Context) error {
+	l, err := h.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	now := time.Now()
+	for _, u := range l {
+		if !u.IsExpired(now) {
+			continue
+		}
+		if err = h.store.Delete(ctx, u.ID); err != nil && !errors.Is(err, types.ErrNotExist) {
+			return fmt.Errorf("delete %s: %w", u.ID, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) checkVersion(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	wine.GetResponseWriter(ctx).Header().Set(HeaderTusResumable, Version)
+	if req.Request().Method == http.MethodOptions {
+		return next(ctx, req)
+	}
+	if v := req.Request().Header.Get(HeaderTusResumable); v != Version {
+		return status(http.StatusPreconditionFailed, http.Header{HeaderTusVersion: {Version}})
+	}
+	return next(ctx, req)
+}
+
+func (h *Handler) handleOptions(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	header := http.Header{}
+	header.Set(HeaderTusVersion, Version)
+	header.Set(HeaderTusExtension, Extensions)
+	if h.MaxSize > 0 {
+		header.Set(HeaderTusMaxSize, fmt.Sprint(h.MaxSize))
+	}
+	return status(http.StatusNoContent, header)
+}
+
+func (h *Handler) create(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	header := req.Request().Header
+	u := &Upload{
+		ID:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Length:    -1,
+		CreatedAt: time.Now(),
+	}
+	if header.Get(HeaderUploadDeferLength) == "1" {
+		if header.Get(HeaderUploadLength) != "" {
+			return wine.Text(http.StatusBadRequest, "Upload-Length and Upload-Defer-Length are exclusive")
+		}
+	} else {
+		n, err := parseLength(header.Get(HeaderUploadLength))
+		if err != nil {
+			return wine.Text(http.StatusBadRequest, err.Error())
+		}
+		if h.MaxSize > 0 && n > h.MaxSize {
+			return wine.Status(http.StatusRequestEntityTooLarge)
+		}
+		u.Length = n
+	}
+	md, err := ParseMetadata(header.Get(HeaderUploadMetadata))
+	if err != nil {
+		return wine.Text(http.StatusBadRequest, err.Error())
+	}
+	u.Metadata = md
+	if h.Expiration > 0 {
+		u.ExpiresAt = u.CreatedAt.Add(h.Expiration)
+	}
+	if err = h.store.Create(ctx, u); err != nil {
+		log.FromContext(ctx).Errorf("Create upload: %v", err)
+		return wine.Status(http.StatusInternalServerError)
+	}
+	if u.IsComplete() && h.OnComplete != nil {
+		h.OnComplete(ctx, u)
+	}
+
+	respHeader := http.Header{}
+	respHeader.Set("Location", strings.TrimSuffix(req.Request().URL.Path, "/")+"/"+u.ID)
+	h.setExpires(respHeader, u)
+	return status(http.StatusCreated, respHeader)
+}
+
+func (h *Handler) head(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	u, resp := h.getUpload(ctx, req)
+	if resp != nil {
+		return resp
+	}
+	header := http.Header{}
+	header.Set("Cache-Control", "no-store")
+	header.Set(HeaderUploadOffset, fmt.Sprint(u.Offset))
+	if u.IsLengthDeferred() {
+		header.Set(HeaderUploadDeferLength, "1")
+	} else {
+		header.Set(HeaderUploadLength, fmt.Sprint(u.Length))
+	}
+	if len(u.Metadata) > 0 {
+		header.Set(HeaderUploadMetadata, FormatMetadata(u.Metadata))
+	}
+	h.setExpires(header, u)
+	return status(http.StatusOK, header)
+}
+
+func (h *Handler) patch(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	header := req.Request().Header
+	if req.ContentType() != mime.OffsetOctetStream {
+		return wine.Status(http.StatusUnsupportedMediaType)
+	}
+	offset, err := parseLength(header.Get(HeaderUploadOffset))
+	if err != nil {
+		return wine.Text(http.StatusBadRequest, "invalid Upload-Offset")
+	}
+	u, resp := h.getUpload(ctx, req)
+	if resp != nil {
+		return resp
+	}
+	if u.Offset != offset {
+		return wine.Status(http.StatusConflict)
+	}
+
+	if u.IsLengthDeferred() && header.Get(HeaderUploadLength) != "" {
+		n, err := parseLength(header.Get(HeaderUploadLength))
+		if err != nil || n < u.Offset {
+			return wine.Text(http.StatusBadRequest, "invalid Upload-Length")
+		}
+		if h.MaxSize > 0 && n > h.MaxSize {
+			return wine.Status(http.StatusRequestEntityTooLarge)
+		}
+		u.Length = n
+		if err = h.store.Update(ctx, u); err != nil {
+			log.FromContext(ctx).Errorf("Update upload: %v", err)
+			return wine.Status(http.StatusInternalServerError)
+		}
+	}
+
+	var body io.Reader = req.Request().Body
+	var remain int64 = -1
+	if !u.IsLengthDeferred() {
+		remain = u.Length - u.Offset
+	} else if h.MaxSize > 0 {
+		remain = h.MaxSize - u.Offset
+	}
+	if remain >= 0 {
+		if req.Request().ContentLength > remain {
+			return wine.Status(http.StatusRequestEntityTooLarge)
+		}
+		body = io.LimitReader(body, remain)
+	}
+	n, err := h.store.Append(ctx, u.ID, offset, body)
+	if err != nil {
+		if errors.Is(err, ErrOffsetMismatch) {
+			return wine.Status(http.StatusConflict)
+		}
+		// Client may resume from the new offset which is returned by HEAD
+		log.FromContext(ctx).Errorf("Append %s at %d: %v", u.ID, offset, err)
+		return wine.Status(http.StatusInternalServerError)
+	}
+	u.Offset += n
+	if u.IsComplete() && h.OnComplete != nil {
+		h.OnComplete(ctx, u)
+	}
+
+	respHeader := http.Header{}
+	respHeader.Set(HeaderUploadOffset, fmt.Sprint(u.Offset))
+	h.setExpires(respHeader, u)
+	return status(http.StatusNoContent, respHeader)
+}
+
+func (h *Handler) delete(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	id := req.Params().String("id")
+	if !idRegexp.MatchString(id) {
+		return wine.Status(http.StatusNotFound)
+	}
+	if err := h.store.Delete(ctx, id); err != nil {
+		if errors.Is(err, types.ErrNotExist) {
+			return wine.Status(http.StatusNotFound)
+		}
+		log.FromContext(ctx).Errorf("Delete upload %s: %v", id, err)
+		return wine.Status(http.StatusInternalServerError)
+	}
+	return status(http.StatusNoContent, nil)
+}
+
+func (h *Handler) getUpload(ctx context.Context, req *wine.Request) (*Upload, wine.Responder) {
+	id := req.Params().String("id")
+	if !idRegexp.MatchString(id) {
+		return nil, wine.Status(http.StatusNotFound)
+	}
+	u, err := h.store.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, types.ErrNotExist) {
+			return nil, wine.Status(http.StatusNotFound)
+		}
+		log.FromContext(ctx).Errorf("Get upload %s: %v", id, err)
+		return nil, wine.Status(http.StatusInternalServerError)
+	}
+	if u.IsExpired(time.Now()) {
+		if err = h.store.Delete(ctx, id); err != nil {
+			log.FromContext(ctx).Errorf("Delete expired upload %s: %v", id, err)
+		}
+		return nil, wine.Status(http.StatusGone)
+	}
+	return u, nil
+}
+
+func (h *Handler) setExpires(header http.Header, u *Upload) {
+	if !u.ExpiresAt.IsZero() && !u.IsComplete() {
+		header.Set(HeaderUploadExpires, u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// status returns a responder without body which is required by 204 and HEAD responses
+func status(code int, header http.Header) wine.Responder {
+	return wine.ResponderFunc(func(ctx context.Context, w http.ResponseWriter) {
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(code)
+	})
+}
+
+func parseLength(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("missing Upload-Length")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid length %s", s)
+	}
+	return n, nil
+}
+
+// ParseMetadata parses Upload-Metadata which consists of comma separated key value pairs.
+// Key and value are separated by a space, value is base64 encoded and may be omitted
+func ParseMetadata(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	md := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.Fields(pair)
+		switch len(kv) {
+		case 1:
+			md[kv[0]] = ""
+		case 2:
+			v, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("decode metadata %s: %w", kv[0], err)
+			}
+			md[kv[0]] = string(v)
+		default:
+			return nil, fmt.Errorf("invalid metadata %s", pair)
+		}
+	}
+	return md, nil
+}
+
+// FormatMetadata encodes md into Upload-Metadata
+func FormatMetadata(md map[string]string) string {
+	l := make([]string, 0, len(md))
+	for k, v := range md {
+		if v == "" {
+			l = append(l, k)
+		} else {
+			l = append(l, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+		}
+	}
+	return strings.Join(l, ",")
+}
@@ -0,0 +1,173 @@
+package tus_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+	"github.com/gopub/wine/tus"
+	"github.com/stretchr/testify/require"
+)
+
+func setupServer(t *testing.T) (*tus.FileStore, *tus.Handler, *httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "tus")
+	require.NoError(t, err)
+	store, err := tus.NewFileStore(dir)
+	require.NoError(t, err)
+	h := tus.NewHandler(store)
+	s := wine.NewServer()
+	h.Mount(s.Group("files"))
+	ts := httptest.NewServer(s)
+	return store, h, ts, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newRequest(t *testing.T, method, url string, body []byte) *http.Request {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(tus.HeaderTusResumable, tus.Version)
+	return req
+}
+
+func do(t *testing.T, req *http.Request) *http.Response {
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestUpload(t *testing.T) {
+	store, h, ts, teardown := setupServer(t)
+	defer teardown()
+	var completed *tus.Upload
+	h.OnComplete = func(ctx context.Context, u *tus.Upload) {
+		completed = u
+	}
+	data := []byte("hello, resumable upload")
+
+	req := newRequest(t, http.MethodPost, ts.URL+"/files", nil)
+	req.Header.Set(tus.HeaderUploadLength, fmt.Sprint(len(data)))
+	req.Header.Set(tus.HeaderUploadMetadata, tus.FormatMetadata(map[string]string{"filename": "a.txt"}))
+	resp := do(t, req)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, tus.Version, resp.Header.Get(tus.HeaderTusResumable))
+	location := resp.Header.Get("Location")
+	require.NotEmpty(t, location)
+
+	t.Run("Patch", func(t *testing.T) {
+		req := newRequest(t, http.MethodPatch, ts.URL+location, data[:5])
+		req.Header.Set(mime.ContentType, mime.OffsetOctetStream)
+		req.Header.Set(tus.HeaderUploadOffset, "0")
+		resp := do(t, req)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		require.Equal(t, "5", resp.Header.Get(tus.HeaderUploadOffset))
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		req := newRequest(t, http.MethodPatch, ts.URL+location, data)
+		req.Header.Set(mime.ContentType, mime.OffsetOctetStream)
+		req.Header.Set(tus.HeaderUploadOffset, "0")
+		resp := do(t, req)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		resp := do(t, newRequest(t, http.MethodHead, ts.URL+location, nil))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "5", resp.Header.Get(tus.HeaderUploadOffset))
+		require.Equal(t, fmt.Sprint(len(data)), resp.Header.Get(tus.HeaderUploadLength))
+		md, err := tus.ParseMetadata(resp.Header.Get(tus.HeaderUploadMetadata))
+		require.NoError(t, err)
+		require.Equal(t, "a.txt", md["filename"])
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		req := newRequest(t, http.MethodPatch, ts.URL+location, data[5:])
+		req.Header.Set(mime.ContentType, mime.OffsetOctetStream)
+		req.Header.Set(tus.HeaderUploadOffset, "5")
+		resp := do(t, req)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		require.Equal(t, fmt.Sprint(len(data)), resp.Header.Get(tus.HeaderUploadOffset))
+		require.NotNil(t, completed)
+		f, err := store.Open(completed.ID)
+		require.NoError(t, err)
+		defer f.Close()
+		b, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, data, b)
+	})
+
+	t.Run("Terminate", func(t *testing.T) {
+		resp := do(t, newRequest(t, http.MethodDelete, ts.URL+location, nil))
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		resp = do(t, newRequest(t, http.MethodHead, ts.URL+location, nil))
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestDeferLength(t *testing.T) {
+	_, _, ts, teardown := setupServer(t)
+	defer teardown()
+	req := newRequest(t, http.MethodPost, ts.URL+"/files", nil)
+	req.Header.Set(tus.HeaderUploadDeferLength, "1")
+	resp := do(t, req)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	location := resp.Header.Get("Location")
+
+	resp = do(t, newRequest(t, http.MethodHead, ts.URL+location, nil))
+	require.Equal(t, "1", resp.Header.Get(tus.HeaderUploadDeferLength))
+
+	req = newRequest(t, http.MethodPatch, ts.URL+location, []byte("abc"))
+	req.Header.Set(mime.ContentType, mime.OffsetOctetStream)
+	req.Header.Set(tus.HeaderUploadOffset, "0")
+	req.Header.Set(tus.HeaderUploadLength, "3")
+	resp = do(t, req)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(t, newRequest(t, http.MethodHead, ts.URL+location, nil))
+	require.Equal(t, "3", resp.Header.Get(tus.HeaderUploadLength))
+	require.Equal(t, "3", resp.Header.Get(tus.HeaderUploadOffset))
+}
+
+func TestExpiration(t *testing.T) {
+	store, h, ts, teardown := setupServer(t)
+	defer teardown()
+	h.Expiration = 50 * time.Millisecond
+	req := newRequest(t, http.MethodPost, ts.URL+"/files", nil)
+	req.Header.Set(tus.HeaderUploadLength, "10")
+	resp := do(t, req)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get(tus.HeaderUploadExpires))
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, h.RemoveExpired(context.Background()))
+	l, err := store.List(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, l)
+}
+
+func TestVersion(t *testing.T) {
+	_, _, ts, teardown := setupServer(t)
+	defer teardown()
+	req := newRequest(t, http.MethodPost, ts.URL+"/files", nil)
+	req.Header.Del(tus.HeaderTusResumable)
+	resp := do(t, req)
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	require.Equal(t, tus.Version, resp.Header.Get(tus.HeaderTusVersion))
+
+	req, err := http.NewRequest(http.MethodOptions, ts.URL+"/files", nil)
+	require.NoError(t, err)
+	resp = do(t, req)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, tus.Extensions, resp.Header.Get(tus.HeaderTusExtension))
+}
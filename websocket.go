@@ -0,0 +1,44 @@
+package wine
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gopub/wine/websocket"
+)
+
+// WebSocketFunc serves an upgraded connection. ctx is canceled once the connection is closed
+type WebSocketFunc func(ctx context.Context, conn *websocket.Conn)
+
+// NewWebSocketHandler returns a handler which upgrades the request and serves the connection with serve.
+// The connection is closed after serve returns. Bind it with NoTimeout as Router.WebSocket does,
+// otherwise ctx of handler chain is canceled once Server.Timeout passes
+func NewWebSocketHandler(options *websocket.UpgradeOptions, serve WebSocketFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request, next Invoker) Responder {
+		return ResponderFunc(func(_ context.Context, w http.ResponseWriter) {
+			conn, err := websocket.Upgrade(w, req.Request(), options)
+			if err != nil {
+				logger.Errorf("Upgrade: %v", err)
+				return
+			}
+			// serve gets values set by interceptors before upgrading, e.g. user id,
+			// but not their deadline as server timeout doesn't apply to long-lived connection
+			connCtx, cancel := context.WithCancel(DetachContext(ctx))
+			defer cancel()
+			go func() {
+				select {
+				case <-conn.Done():
+					cancel()
+				case <-connCtx.Done():
+				}
+			}()
+			defer conn.Close()
+			serve(connCtx, conn)
+		})
+	}
+}
+
+// WebSocket binds a websocket endpoint. Handlers of r, e.g. auth and session interceptors, are invoked before upgrading
+func (r *Router) WebSocket(path string, serve WebSocketFunc) {
+	r.Bind(http.MethodGet, path, NoTimeout, NewWebSocketHandler(nil, serve))
+}
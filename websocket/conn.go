@@ -0,0 +1,554 @@
+// Package websocket implements WebSocket protocol (RFC 6455) without extensions
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+// Message types
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	maxControlPayloadLen = 125
+	closeTimeout         = time.Second
+)
+
+var (
+	ErrClosed         = errors.New("websocket: connection closed")
+	ErrUnexpectedType = errors.New("websocket: unexpected message type")
+)
+
+// CloseError is returned by Read methods after receiving a close frame or failing the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// IsCloseError returns true if err is a CloseError with one of codes
+func IsCloseError(err error, codes ...int) bool {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	for _, c := range codes {
+		if ce.Code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configures a connection. Zero values are replaced with defaults
+type Options struct {
+	// Subprotocols are supported by server or offered by client in order of preference
+	Subprotocols []string
+	// MaxMessageSize limits size of a received message, default is 1MB
+	MaxMessageSize int64
+	// SendQueueSize is the capacity of outgoing message queue, default is 64
+	SendQueueSize int
+	// PingInterval is the interval of sending pings, default is 30 seconds. Negative value disables keepalive
+	PingInterval time.Duration
+	// PongTimeout is the time to wait for peer's response after PingInterval, default is 10 seconds
+	PongTimeout time.Duration
+	// WriteTimeout is the deadline of writing a frame, default is 10 seconds
+	WriteTimeout time.Duration
+}
+
+func (o *Options) withDefaults() Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.MaxMessageSize <= 0 {
+		opts.MaxMessageSize = 1 << 20
+	}
+	if opts.SendQueueSize <= 0 {
+		opts.SendQueueSize = 64
+	}
+	if opts.PingInterval == 0 {
+		opts.PingInterval = 30 * time.Second
+	}
+	if opts.PongTimeout <= 0 {
+		opts.PongTimeout = 10 * time.Second
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	return opts
+}
+
+type frame struct {
+	opcode  byte
+	payload []byte
+}
+
+// Conn is a WebSocket connection.
+// Write methods put messages into a bounded send queue which is drained by a background goroutine.
+// Read methods should be called from one goroutine, pings and close frames are handled while reading.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	isServer    bool
+	options     Options
+	subprotocol string
+
+	send    chan *frame
+	control chan *frame
+
+	closeSent     int32
+	closeReceived int32
+	closeOnce     sync.Once
+	closed        chan struct{}
+	err           error
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, isServer bool, options Options, subprotocol string) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	c := &Conn{
+		conn:        conn,
+		br:          br,
+		isServer:    isServer,
+		options:     options,
+		subprotocol: subprotocol,
+		send:        make(chan *frame, options.SendQueueSize),
+		control:     make(chan *frame, 4),
+		closed:      make(chan struct{}),
+	}
+	c.extendReadDeadline()
+	go c.writeLoop()
+	return c
+}
+
+// Subprotocol returns the negotiated subprotocol
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Done returns a channel which is closed after the underlying connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns the reason of closing
+func (c *Conn) Err() error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// WriteMessage puts a message into send queue. It blocks if the queue is full
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return ErrUnexpectedType
+	}
+	if atomic.LoadInt32(&c.closeSent) != 0 {
+		return ErrClosed
+	}
+	select {
+	case c.send <- &frame{opcode: byte(typ), payload: data}:
+		return nil
+	case <-c.closed:
+		return ErrClosed
+	}
+}
+
+func (c *Conn) WriteText(s string) error {
+	return c.WriteMessage(TextMessage, []byte(s))
+}
+
+func (c *Conn) WriteBinary(b []byte) error {
+	return c.WriteMessage(BinaryMessage, b)
+}
+
+// WriteJSON sends v as a text message
+func (c *Conn) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return c.WriteMessage(TextMessage, b)
+}
+
+// ReadMessage reads a complete message. Control frames are handled internally
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.readFailed(err)
+		}
+		c.extendReadDeadline()
+		switch op {
+		case opPing:
+			c.sendControl(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(payload)
+		case opText, opBinary:
+			if started {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected data frame")
+			}
+			started = true
+			typ = MessageType(op)
+			msg = payload
+		case opContinuation:
+			if !started {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+		if int64(len(msg)) > c.options.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "")
+		}
+		if fin {
+			if typ == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid utf-8")
+			}
+			return typ, msg, nil
+		}
+	}
+}
+
+// ReadText reads a text message
+func (c *Conn) ReadText() (string, error) {
+	typ, b, err := c.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	if typ != TextMessage {
+		return "", ErrUnexpectedType
+	}
+	return string(b), nil
+}
+
+// ReadBinary reads a binary message
+func (c *Conn) ReadBinary() ([]byte, error) {
+	typ, b, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if typ != BinaryMessage {
+		return nil, ErrUnexpectedType
+	}
+	return b, nil
+}
+
+// ReadJSON reads a message and unmarshal it into v
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, b, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	return nil
+}
+
+// Close starts closing handshake with CloseNormal
+func (c *Conn) Close() error {
+	return c.CloseWithReason(CloseNormal, "")
+}
+
+// CloseWithReason sends a close frame after queued messages, then the connection is closed once peer replies or
+// timeout
+func (c *Conn) CloseWithReason(code int, reason string) error {
+	if !atomic.CompareAndSwapInt32(&c.closeSent, 0, 1) {
+		return nil
+	}
+	f := &frame{opcode: opClose, payload: closePayload(code, reason)}
+	select {
+	case c.send <- f:
+	case <-c.closed:
+	case <-time.After(c.options.WriteTimeout):
+		c.shutdown(ErrClosed)
+	}
+	return nil
+}
+
+func (c *Conn) writeLoop() {
+	var tick <-chan time.Time
+	if c.options.PingInterval > 0 {
+		ticker := time.NewTicker(c.options.PingInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		var f *frame
+		select {
+		case f = <-c.control:
+		default:
+			select {
+			case f = <-c.control:
+			case f = <-c.send:
+			case <-tick:
+				f = &frame{opcode: opPing}
+			case <-c.closed:
+				return
+			}
+		}
+		if err := c.writeFrame(f); err != nil {
+			c.shutdown(err)
+			return
+		}
+		if f.opcode == opClose {
+			if atomic.LoadInt32(&c.closeReceived) != 0 {
+				// Replied peer's close frame
+				c.shutdown(ErrClosed)
+			} else {
+				time.AfterFunc(closeTimeout, func() {
+					c.shutdown(ErrClosed)
+				})
+			}
+			return
+		}
+	}
+}
+
+func (c *Conn) writeFrame(f *frame) error {
+	n := len(f.payload)
+	head := make([]byte, 2, 14)
+	head[0] = 0x80 | f.opcode
+	switch {
+	case n < 126:
+		head[1] = byte(n)
+	case n <= 0xFFFF:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	payload := f.payload
+	if !c.isServer {
+		// Client must mask all frames
+		head[1] |= 0x80
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return fmt.Errorf("generate mask key: %w", err)
+		}
+		head = append(head, key[:]...)
+		payload = make([]byte, n)
+		copy(payload, f.payload)
+		maskBytes(key, payload)
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(append(head, payload...)); err != nil {
+		return fmt.Errorf("write frame: %w", err)
+	}
+	return nil
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		err = &CloseError{Code: CloseProtocolError, Text: "unexpected reserved bits"}
+		return
+	}
+	masked := head[1]&0x80 != 0
+	if masked != c.isServer {
+		err = &CloseError{Code: CloseProtocolError, Text: "bad frame masking"}
+		return
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if op >= opClose && (!fin || n > maxControlPayloadLen) {
+		err = &CloseError{Code: CloseProtocolError, Text: "bad control frame"}
+		return
+	}
+	if n > uint64(c.options.MaxMessageSize) {
+		err = &CloseError{Code: CloseMessageTooBig}
+		return
+	}
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, key[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+	return
+}
+
+func (c *Conn) readFailed(err error) error {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		return c.fail(ce.Code, ce.Text)
+	}
+	select {
+	case <-c.closed:
+		if c.err != nil && c.err != ErrClosed {
+			return c.err
+		}
+		return &CloseError{Code: CloseAbnormal}
+	default:
+	}
+	c.shutdown(err)
+	return err
+}
+
+// fail closes connection because of an error
+func (c *Conn) fail(code int, reason string) error {
+	if atomic.CompareAndSwapInt32(&c.closeSent, 0, 1) {
+		c.sendControl(opClose, closePayload(code, reason))
+	}
+	err := &CloseError{Code: code, Text: reason}
+	time.AfterFunc(closeTimeout, func() {
+		c.shutdown(err)
+	})
+	return err
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+	}
+	atomic.StoreInt32(&c.closeReceived, 1)
+	if atomic.CompareAndSwapInt32(&c.closeSent, 0, 1) {
+		code := ce.Code
+		if code == CloseNoStatus {
+			code = CloseNormal
+		}
+		c.sendControl(opClose, closePayload(code, ""))
+	} else {
+		// Closing handshake completed
+		c.shutdown(ErrClosed)
+	}
+	return ce
+}
+
+func (c *Conn) sendControl(op byte, payload []byte) {
+	select {
+	case c.control <- &frame{opcode: op, payload: payload}:
+	case <-c.closed:
+	}
+}
+
+func (c *Conn) extendReadDeadline() {
+	if c.options.PingInterval > 0 {
+		deadline := time.Now().Add(c.options.PingInterval + c.options.PongTimeout)
+		if err := c.conn.SetReadDeadline(deadline); err != nil {
+			c.shutdown(err)
+		}
+	}
+}
+
+func (c *Conn) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatus {
+		return nil
+	}
+	if len(reason) > maxControlPayloadLen-2 {
+		reason = reason[:maxControlPayloadLen-2]
+	}
+	b := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(code))
+	copy(b[2:], reason)
+	return b
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DialOptions configures client side connections
+type DialOptions struct {
+	Options
+	// TLSConfig is used for wss connections
+	TLSConfig *tls.Config
+	// Header is sent with handshake request
+	Header http.Header
+}
+
+// Dial connects to a WebSocket server. rawURL's scheme can be ws, wss, http or https
+func Dial(ctx context.Context, rawURL string, options *DialOptions) (*Conn, *http.Response, error) {
+	if options == nil {
+		options = new(DialOptions)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse url: %w", err)
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+	case "wss", "https":
+		secure = true
+	default:
+		return nil, nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if secure {
+		cfg := options.TLSConfig
+		if cfg == nil {
+			cfg = new(tls.Config)
+		} else {
+			cfg = cfg.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		// Upgrade is only available in HTTP/1.1
+		cfg.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	conn, br, resp, subprotocol, err := handshake(conn, u, options)
+	if err != nil {
+		return nil, resp, err
+	}
+	conn.SetDeadline(time.Time{})
+	return newConn(conn, br, false, options.withDefaults(), subprotocol), resp, nil
+}
+
+func handshake(conn net.Conn, u *url.URL, options *DialOptions) (net.Conn, *bufio.Reader, *http.Response, string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		conn.Close()
+		return nil, nil, nil, "", fmt.Errorf("generate key: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	header := make(http.Header, len(options.Header)+5)
+	for k, v := range options.Header {
+		header[k] = v
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set(HeaderSecKey, key)
+	header.Set(HeaderSecVersion, "13")
+	if len(options.Subprotocols) > 0 {
+		header.Set(HeaderSecProtocol, strings.Join(options.Subprotocols, ", "))
+	}
+	reqURL := *u
+	switch reqURL.Scheme {
+	case "ws":
+		reqURL.Scheme = "http"
+	case "wss":
+		reqURL.Scheme = "https"
+	}
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &reqURL,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Host:       u.Host,
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, nil, "", fmt.Errorf("write handshake: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, "", fmt.Errorf("read handshake: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContainsToken(resp.Header, "Upgrade", "websocket") ||
+		!headerContainsToken(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get(HeaderSecAccept) != computeAccept(key) {
+		conn.Close()
+		return nil, nil, resp, "", fmt.Errorf("%w: status %d", ErrBadHandshake, resp.StatusCode)
+	}
+	subprotocol := resp.Header.Get(HeaderSecProtocol)
+	return conn, br, resp, subprotocol, nil
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Header keys
+const (
+	HeaderSecKey      = "Sec-WebSocket-Key"
+	HeaderSecAccept   = "Sec-WebSocket-Accept"
+	HeaderSecVersion  = "Sec-WebSocket-Version"
+	HeaderSecProtocol = "Sec-WebSocket-Protocol"
+)
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// CheckOrigin returns false if Origin header exists and its host is different from request host.
+// It's used if UpgradeOptions.CheckOrigin is nil
+func CheckOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+// UpgradeOptions configures server side connections
+type UpgradeOptions struct {
+	Options
+	// CheckOrigin returns true if request origin is acceptable
+	CheckOrigin func(req *http.Request) bool
+}
+
+// Upgrade upgrades HTTP connection to WebSocket. Headers already set in w are included in handshake response.
+// Error response is written if request isn't a valid handshake
+func Upgrade(w http.ResponseWriter, req *http.Request, options *UpgradeOptions) (*Conn, error) {
+	if options == nil {
+		options = new(UpgradeOptions)
+	}
+	if req.Method != http.MethodGet {
+		return nil, upgradeFailed(w, http.StatusMethodNotAllowed, "method must be GET")
+	}
+	if !headerContainsToken(req.Header, "Connection", "upgrade") {
+		return nil, upgradeFailed(w, http.StatusBadRequest, "missing connection upgrade")
+	}
+	if !headerContainsToken(req.Header, "Upgrade", "websocket") {
+		return nil, upgradeFailed(w, http.StatusBadRequest, "missing upgrade websocket")
+	}
+	if req.Header.Get(HeaderSecVersion) != "13" {
+		w.Header().Set(HeaderSecVersion, "13")
+		return nil, upgradeFailed(w, http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := req.Header.Get(HeaderSecKey)
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, upgradeFailed(w, http.StatusBadRequest, "invalid "+HeaderSecKey)
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = CheckOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, upgradeFailed(w, http.StatusForbidden, "origin not allowed")
+	}
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, upgradeFailed(w, http.StatusInternalServerError, "hijacking is not supported")
+	}
+	subprotocol := selectSubprotocol(req, options.Subprotocols)
+
+	b := new(strings.Builder)
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString(HeaderSecAccept + ": " + computeAccept(key) + "\r\n")
+	if subprotocol != "" {
+		b.WriteString(HeaderSecProtocol + ": " + subprotocol + "\r\n")
+	}
+	for k, vs := range w.Header() {
+		switch k {
+		case "Content-Type", "Content-Length", "Content-Encoding", "Vary":
+			continue
+		}
+		for _, v := range vs {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack: %w", err)
+	}
+	if _, err = conn.Write([]byte(b.String())); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+	return newConn(conn, brw.Reader, true, options.withDefaults(), subprotocol), nil
+}
+
+// IsUpgradeRequest returns true if req asks for upgrading to WebSocket
+func IsUpgradeRequest(req *http.Request) bool {
+	return headerContainsToken(req.Header, "Connection", "upgrade") &&
+		headerContainsToken(req.Header, "Upgrade", "websocket")
+}
+
+func upgradeFailed(w http.ResponseWriter, status int, reason string) error {
+	http.Error(w, reason, status)
+	return fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+}
+
+func selectSubprotocol(req *http.Request, supported []string) string {
+	for _, p := range headerTokens(req.Header, HeaderSecProtocol) {
+		for _, s := range supported {
+			if p == s {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+func computeAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerTokens(h http.Header, name string) []string {
+	var l []string
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				l = append(l, t)
+			}
+		}
+	}
+	return l
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package websocket_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopub/types"
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/api"
+	"github.com/gopub/wine/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func wsURL(ts *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + path
+}
+
+func echo(ctx context.Context, conn *websocket.Conn) {
+	for {
+		typ, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err = conn.WriteMessage(typ, b); err != nil {
+			return
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	s := wine.NewServer()
+	s.WebSocket("echo", echo)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.Dial(context.Background(), wsURL(ts, "/echo"), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	t.Run("Text", func(t *testing.T) {
+		require.NoError(t, conn.WriteText("hello"))
+		s, err := conn.ReadText()
+		require.NoError(t, err)
+		require.Equal(t, "hello", s)
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		b := make([]byte, 70000)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		require.NoError(t, conn.WriteBinary(b))
+		res, err := conn.ReadBinary()
+		require.NoError(t, err)
+		require.Equal(t, b, res)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		v := types.M{"id": 1.0, "name": "wine"}
+		require.NoError(t, conn.WriteJSON(v))
+		var res types.M
+		require.NoError(t, conn.ReadJSON(&res))
+		require.Equal(t, v, res)
+	})
+}
+
+func TestHandlerChain(t *testing.T) {
+	s := wine.NewServer()
+	g := s.Group("api").Use(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		if req.Request().Header.Get("Authorization") != "Bearer token" {
+			return wine.Status(http.StatusUnauthorized)
+		}
+		return next(wine.WithUserID(ctx, 7), req)
+	})
+	g.WebSocket("chat", func(ctx context.Context, conn *websocket.Conn) {
+		conn.WriteJSON(wine.GetUserID(ctx))
+	})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		_, resp, err := websocket.Dial(context.Background(), wsURL(ts, "/api/chat"), nil)
+		require.Error(t, err)
+		require.NotNil(t, resp)
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		c := api.NewClient(http.DefaultClient)
+		c.Header().Set("Authorization", "Bearer token")
+		conn, err := c.DialWebSocket(context.Background(), wsURL(ts, "/api/chat"), nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		var uid int64
+		require.NoError(t, conn.ReadJSON(&uid))
+		require.Equal(t, int64(7), uid)
+		_, _, err = conn.ReadMessage()
+		require.True(t, websocket.IsCloseError(err, websocket.CloseNormal))
+	})
+}
+
+func TestClose(t *testing.T) {
+	s := wine.NewServer()
+	s.WebSocket("close", func(ctx context.Context, conn *websocket.Conn) {
+		conn.CloseWithReason(websocket.ClosePolicyViolation, "bye")
+	})
+	s.Get("small", wine.NewWebSocketHandler(&websocket.UpgradeOptions{
+		Options: websocket.Options{MaxMessageSize: 8},
+	}, echo))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Code", func(t *testing.T) {
+		conn, _, err := websocket.Dial(context.Background(), wsURL(ts, "/close"), nil)
+		require.NoError(t, err)
+		_, _, err = conn.ReadMessage()
+		require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+		require.Equal(t, "bye", err.(*websocket.CloseError).Text)
+		select {
+		case <-conn.Done():
+		case <-time.After(time.Second):
+			t.Fatal("connection isn't closed")
+		}
+		require.Equal(t, websocket.ErrClosed, conn.WriteText("hi"))
+	})
+
+	t.Run("MessageTooBig", func(t *testing.T) {
+		conn, _, err := websocket.Dial(context.Background(), wsURL(ts, "/small"), nil)
+		require.NoError(t, err)
+		require.NoError(t, conn.WriteText("0123456789"))
+		_, _, err = conn.ReadMessage()
+		require.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+	})
+}
+
+func TestKeepalive(t *testing.T) {
+	done := make(chan error, 1)
+	s := wine.NewServer()
+	s.Get("ws", wine.NewWebSocketHandler(&websocket.UpgradeOptions{
+		Options: websocket.Options{PingInterval: 20 * time.Millisecond, PongTimeout: 20 * time.Millisecond},
+	}, func(ctx context.Context, conn *websocket.Conn) {
+		_, _, err := conn.ReadMessage()
+		done <- err
+	}))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	t.Run("Alive", func(t *testing.T) {
+		// Client answers pings while reading
+		conn, _, err := websocket.Dial(context.Background(), wsURL(ts, "/ws"), &websocket.DialOptions{
+			Options: websocket.Options{PingInterval: -1},
+		})
+		require.NoError(t, err)
+		go conn.ReadMessage()
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, conn.WriteText("hi"))
+		require.NoError(t, <-done)
+		conn.Close()
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		// Client doesn't read, so pings are never answered
+		conn, _, err := websocket.Dial(context.Background(), wsURL(ts, "/ws"), &websocket.DialOptions{
+			Options: websocket.Options{PingInterval: -1},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+		select {
+		case err := <-done:
+			require.Error(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("keepalive timeout isn't detected")
+		}
+	})
+}
+
+func TestUpgradeOrigin(t *testing.T) {
+	s := wine.NewServer()
+	s.WebSocket("ws", echo)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	header := make(http.Header)
+	header.Set("Origin", "http://evil.com")
+	_, resp, err := websocket.Dial(context.Background(), wsURL(ts, "/ws"), &websocket.DialOptions{Header: header})
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestTimeout(t *testing.T) {
+	s := wine.NewServer(wine.WithTimeout(100 * time.Millisecond))
+	chainCtx := make(chan context.Context, 1)
+	s.Use(func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		chainCtx <- ctx
+		return next(ctx, req)
+	}).WebSocket("echo", echo)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	conn, _, err := websocket.Dial(context.Background(), wsURL(ts, "/echo"), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, conn.WriteText("hello"))
+	res, err := conn.ReadText()
+	require.NoError(t, err)
+	require.Equal(t, "hello", res)
+	require.NoError(t, (<-chainCtx).Err())
+}
@@ -0,0 +1,317 @@
+// Package winetest serves requests with wine server in process, without opening sockets.
+//
+//	ts := winetest.New(t, s)
+//	var item Item
+//	ts.Get("/items/1").WithBearer(token).Expect(http.StatusOK).JSON(&item)
+package winetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/mime"
+)
+
+// update rewrites golden files with actual responses, e.g. go test ./... -winetest.update
+var update = flag.Bool("winetest.update", false, "update golden files of winetest")
+
+// GoldenDir is the directory of golden files
+var GoldenDir = "testdata"
+
+// Server sends requests to the wrapped server
+type Server struct {
+	t      testing.TB
+	server *wine.Server
+}
+
+// New returns a test server of s
+func New(t testing.TB, s *wine.Server) *Server {
+	return &Server{
+		t:      t,
+		server: s,
+	}
+}
+
+// NewRouter returns a test server of r, which is served by a server with default config
+func NewRouter(t testing.TB, r *wine.Router) *Server {
+	s := wine.NewServer()
+	s.Router = r
+	return New(t, s)
+}
+
+func (s *Server) Get(path string) *RequestBuilder {
+	return s.Request(http.MethodGet, path)
+}
+
+func (s *Server) Head(path string) *RequestBuilder {
+	return s.Request(http.MethodHead, path)
+}
+
+func (s *Server) Post(path string) *RequestBuilder {
+	return s.Request(http.MethodPost, path)
+}
+
+func (s *Server) Put(path string) *RequestBuilder {
+	return s.Request(http.MethodPut, path)
+}
+
+func (s *Server) Patch(path string) *RequestBuilder {
+	return s.Request(http.MethodPatch, path)
+}
+
+func (s *Server) Delete(path string) *RequestBuilder {
+	return s.Request(http.MethodDelete, path)
+}
+
+func (s *Server) Options(path string) *RequestBuilder {
+	return s.Request(http.MethodOptions, path)
+}
+
+// Request returns a builder of request with method and path, which may contain query, e.g. "/items?page=2"
+func (s *Server) Request(method, path string) *RequestBuilder {
+	return &RequestBuilder{
+		t:      s.t,
+		method: method,
+		path:   path,
+		header: make(http.Header),
+		query:  make(url.Values),
+		send: func(req *http.Request) *Response {
+			w := httptest.NewRecorder()
+			s.server.ServeHTTP(w, req)
+			return &Response{t: s.t, Recorder: w}
+		},
+	}
+}
+
+// RequestBuilder builds and sends a request
+type RequestBuilder struct {
+	t      testing.TB
+	method string
+	path   string
+	header http.Header
+	query  url.Values
+	body   io.Reader
+	ctx    context.Context
+	send   func(req *http.Request) *Response
+}
+
+// Method sets method of the request
+func (b *RequestBuilder) Method(method string) *RequestBuilder {
+	b.method = method
+	return b
+}
+
+// Path sets path of the request
+func (b *RequestBuilder) Path(path string) *RequestBuilder {
+	b.path = path
+	return b
+}
+
+func (b *RequestBuilder) WithHeader(key, value string) *RequestBuilder {
+	b.header.Add(key, value)
+	return b
+}
+
+// WithBearer sets Authorization header with bearer token
+func (b *RequestBuilder) WithBearer(token string) *RequestBuilder {
+	b.header.Set("Authorization", "Bearer "+token)
+	return b
+}
+
+func (b *RequestBuilder) WithBasicAuth(user, password string) *RequestBuilder {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(user, password)
+	b.header.Set("Authorization", req.Header.Get("Authorization"))
+	return b
+}
+
+func (b *RequestBuilder) WithQuery(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+func (b *RequestBuilder) WithCookie(c *http.Cookie) *RequestBuilder {
+	b.header.Add("Cookie", c.String())
+	return b
+}
+
+// WithBody sets body with content type
+func (b *RequestBuilder) WithBody(contentType string, body []byte) *RequestBuilder {
+	b.header.Set(mime.ContentType, contentType)
+	b.body = bytes.NewReader(body)
+	return b
+}
+
+// WithJSON sets body with v encoded in JSON
+func (b *RequestBuilder) WithJSON(v interface{}) *RequestBuilder {
+	body, err := json.Marshal(v)
+	if err != nil {
+		b.t.Fatalf("Marshal: %v", err)
+	}
+	return b.WithBody(mime.JSON, body)
+}
+
+// WithForm sets body with url encoded form
+func (b *RequestBuilder) WithForm(form url.Values) *RequestBuilder {
+	return b.WithBody(mime.FormURLEncoded, []byte(form.Encode()))
+}
+
+func (b *RequestBuilder) WithContext(ctx context.Context) *RequestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// Build returns the http request
+func (b *RequestBuilder) Build() *http.Request {
+	req := httptest.NewRequest(b.method, b.path, b.body)
+	if len(b.query) > 0 {
+		q := req.URL.Query()
+		for k, vs := range b.query {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		req.URL.RawQuery = q.Encode()
+		req.RequestURI = req.URL.RequestURI()
+	}
+	for k, vs := range b.header {
+		req.Header[k] = vs
+	}
+	if b.ctx != nil {
+		req = req.WithContext(b.ctx)
+	}
+	return req
+}
+
+// Send sends the request
+func (b *RequestBuilder) Send() *Response {
+	return b.send(b.Build())
+}
+
+// Expect sends the request, and fails the test if response status isn't status
+func (b *RequestBuilder) Expect(status int) *Response {
+	b.t.Helper()
+	return b.Send().Expect(status)
+}
+
+// Response is the recorded response
+type Response struct {
+	t testing.TB
+	// Responder is returned by the handler called by Invoke. It's nil for requests sent to Server
+	Responder wine.Responder
+	Recorder  *httptest.ResponseRecorder
+}
+
+func (r *Response) Status() int {
+	return r.Recorder.Code
+}
+
+func (r *Response) Header() http.Header {
+	return r.Recorder.Header()
+}
+
+func (r *Response) Body() []byte {
+	return r.Recorder.Body.Bytes()
+}
+
+func (r *Response) Text() string {
+	return r.Recorder.Body.String()
+}
+
+// Expect fails the test if status isn't status
+func (r *Response) Expect(status int) *Response {
+	r.t.Helper()
+	if r.Status() != status {
+		r.t.Fatalf("Expect status %d, got %d: %s", status, r.Status(), r.Text())
+	}
+	return r
+}
+
+// ExpectHeader fails the test if header value of key isn't value
+func (r *Response) ExpectHeader(key, value string) *Response {
+	r.t.Helper()
+	if v := r.Header().Get(key); v != value {
+		r.t.Fatalf("Expect header %s %q, got %q", key, value, v)
+	}
+	return r
+}
+
+// JSON decodes body into v, and fails the test on error
+func (r *Response) JSON(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body(), v); err != nil {
+		r.t.Fatalf("Unmarshal %s: %v", r.Text(), err)
+	}
+	return r
+}
+
+// Golden compares body with golden file GoldenDir/name.golden, and fails the test if they are different.
+// JSON body is indented before comparing. Golden file is written instead if flag -winetest.update is set
+func (r *Response) Golden(name string) *Response {
+	r.t.Helper()
+	body := r.Body()
+	if strings.HasPrefix(r.Header().Get(mime.ContentType), mime.JSON) {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, body, "", "  "); err == nil {
+			buf.WriteByte('\n')
+			body = buf.Bytes()
+		}
+	}
+	filename := filepath.Join(GoldenDir, name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			r.t.Fatalf("Create dir: %v", err)
+		}
+		if err := ioutil.WriteFile(filename, body, 0644); err != nil {
+			r.t.Fatalf("Write golden file: %v", err)
+		}
+		return r
+	}
+	expected, err := ioutil.ReadFile(filename)
+	if err != nil {
+		r.t.Fatalf("Read golden file: %v, run with -winetest.update to create it", err)
+	}
+	if !bytes.Equal(expected, body) {
+		r.t.Fatalf("Body doesn't match %s\nexpected:\n%s\nactual:\n%s", filename, expected, body)
+	}
+	return r
+}
+
+// Invoke calls h with the request built by the returned builder. next is called if h passes the request on,
+// default is responding 404. The returned Responder is saved in Response.Responder
+//
+//	resp := winetest.Invoke(t, authHandler, nil).WithBearer(token).Expect(http.StatusUnauthorized)
+func Invoke(t testing.TB, h wine.Handler, next wine.Invoker) *RequestBuilder {
+	if next == nil {
+		next = func(ctx context.Context, req *wine.Request) wine.Responder {
+			return wine.Status(http.StatusNotFound)
+		}
+	}
+	s := wine.NewServer()
+	var resp wine.Responder
+	// PreHandler is called with context set up by server, e.g. response writer and session
+	s.PreHandler = wine.HandlerFunc(func(ctx context.Context, req *wine.Request, _ wine.Invoker) wine.Responder {
+		resp = h.HandleRequest(ctx, req, next)
+		return resp
+	})
+	b := New(t, s).Get("/")
+	send := b.send
+	b.send = func(req *http.Request) *Response {
+		r := send(req)
+		r.Responder = resp
+		return r
+	}
+	return b
+}
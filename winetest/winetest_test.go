@@ -0,0 +1,112 @@
+package winetest_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gopub/types"
+	"github.com/gopub/wine"
+	"github.com/gopub/wine/winetest"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func requireBearer(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+	if req.Bearer() != "secret" {
+		return wine.Status(http.StatusUnauthorized)
+	}
+	return next(ctx, req)
+}
+
+func newTestRouter() *wine.Router {
+	r := wine.NewRouter()
+	r.Get("items/{id}", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.JSON(http.StatusOK, &item{ID: req.Params().String("id"), Name: "apple"})
+	})
+	r.Get("search", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, req.Params().String("q"))
+	})
+	r.Post("items", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.JSON(http.StatusCreated, &item{ID: "2", Name: req.Params().String("name")})
+	})
+	r.UseHandlers(wine.HandlerFunc(requireBearer)).Get("private", func(ctx context.Context, req *wine.Request, next wine.Invoker) wine.Responder {
+		return wine.Text(http.StatusOK, "private")
+	})
+	return r
+}
+
+func TestServer(t *testing.T) {
+	ts := winetest.NewRouter(t, newTestRouter())
+	t.Run("JSON", func(t *testing.T) {
+		var v item
+		ts.Get("/items/1").Expect(http.StatusOK).JSON(&v)
+		require.Equal(t, item{ID: "1", Name: "apple"}, v)
+	})
+	t.Run("Query", func(t *testing.T) {
+		resp := ts.Get("/search").WithQuery("q", "wine").Expect(http.StatusOK)
+		require.Equal(t, "wine", resp.Text())
+	})
+	t.Run("PostJSON", func(t *testing.T) {
+		var v item
+		ts.Post("/items").WithJSON(types.M{"name": "pear"}).Expect(http.StatusCreated).JSON(&v)
+		require.Equal(t, "pear", v.Name)
+	})
+	t.Run("PostForm", func(t *testing.T) {
+		var v item
+		ts.Post("/items").WithForm(url.Values{"name": {"peach"}}).Expect(http.StatusCreated).JSON(&v)
+		require.Equal(t, "peach", v.Name)
+	})
+	t.Run("Bearer", func(t *testing.T) {
+		ts.Get("/private").Expect(http.StatusUnauthorized)
+		resp := ts.Get("/private").WithBearer("secret").Expect(http.StatusOK)
+		require.Equal(t, "private", resp.Text())
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		ts.Get("/unknown").Expect(http.StatusNotFound)
+	})
+	t.Run("Golden", func(t *testing.T) {
+		ts.Get("/items/1").Expect(http.StatusOK).Golden("item")
+	})
+}
+
+func TestInvoke(t *testing.T) {
+	h := wine.HandlerFunc(requireBearer)
+	t.Run("Reject", func(t *testing.T) {
+		resp := winetest.Invoke(t, h, nil).Expect(http.StatusUnauthorized)
+		r, ok := resp.Responder.(*wine.Response)
+		require.True(t, ok)
+		require.Equal(t, http.StatusUnauthorized, r.Status())
+	})
+	t.Run("Next", func(t *testing.T) {
+		called := false
+		next := func(ctx context.Context, req *wine.Request) wine.Responder {
+			called = true
+			return wine.JSON(http.StatusOK, types.M{"user": "tom"})
+		}
+		var v types.M
+		resp := winetest.Invoke(t, h, next).WithBearer("secret").Expect(http.StatusOK).JSON(&v)
+		require.True(t, called)
+		require.Equal(t, "tom", v.String("user"))
+		require.NotNil(t, resp.Responder)
+		resp.Golden("invoke_next")
+	})
+	t.Run("DefaultNext", func(t *testing.T) {
+		winetest.Invoke(t, h, nil).WithBearer("secret").Expect(http.StatusNotFound)
+	})
+	t.Run("Header", func(t *testing.T) {
+		etag := wine.NewETagHandler(false, "no-cache")
+		next := func(ctx context.Context, req *wine.Request) wine.Responder {
+			return wine.Text(http.StatusOK, "hello")
+		}
+		resp := winetest.Invoke(t, etag, next).Expect(http.StatusOK)
+		etagValue := resp.Header().Get("ETag")
+		require.NotEmpty(t, etagValue)
+		winetest.Invoke(t, etag, next).WithHeader("If-None-Match", etagValue).Expect(http.StatusNotModified)
+	})
+}